@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/alecthomas/kong"
+	"github.com/aws/aws-sdk-go-v2/credentials/processcreds"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/audit"
+	"github.com/scizorman/op-aws-credential-process/pkg/sessioncache"
+)
+
+const agentSockEnv = "OP_AWS_CP_AGENT_SOCK"
+
+var errAgentUnavailable = errors.New("agent is unavailable")
+
+type agentCmd struct {
+	Socket string `help:"Unix socket path. Defaults to $OP_AWS_CP_AGENT_SOCK, then $XDG_RUNTIME_DIR/op-aws-credential-process/agent.sock. Its directory must be owned by the current user with mode 0700."`
+}
+
+// agentOptions are the flags a client may pass to the agent: those that
+// select the cached session and how it is issued. Flags naming programs or
+// commands to run are not among them, so whoever reaches the socket cannot
+// make the agent execute anything else.
+var agentOptions = map[string]bool{
+	"duration":                      true,
+	"expiry-window":                 true,
+	"backend":                       true,
+	"op-vault":                      true,
+	"op-item":                       true,
+	"op-access-key-id-field":        true,
+	"op-secret-access-key-field":    true,
+	"op-totp-secret-field":          true,
+	"bw-item":                       true,
+	"bw-access-key-id-field":        true,
+	"bw-secret-access-key-field":    true,
+	"pass-entry":                    true,
+	"pass-access-key-id-field":      true,
+	"pass-secret-access-key-field":  true,
+	"vault-addr":                    true,
+	"vault-mount":                   true,
+	"vault-path":                    true,
+	"vault-namespace":               true,
+	"vault-access-key-id-field":     true,
+	"vault-secret-access-key-field": true,
+	"sts-timeout":                   true,
+	"sts-endpoint":                  true,
+	"role-arn":                      true,
+	"role-session-name":             true,
+	"tag":                           true,
+	"transitive-tag-key":            true,
+	"source-identity":               true,
+	"session-policy-file":           true,
+	"session-policy-arn":            true,
+	"federation":                    true,
+	"federation-name":               true,
+	"policy-file":                   true,
+	"policy-arn":                    true,
+	"web-identity-token-file":       true,
+	"mfa-serial":                    true,
+	"mfa-source":                    true,
+	"mfa-retries":                   true,
+	"ykman-account":                 true,
+	"mfa-timeout":                   true,
+	"refresh-window":                true,
+}
+
+// agentLocalOptions only affect the client process, so they are not sent.
+var agentLocalOptions = map[string]bool{
+	"profile":      true,
+	"log-level":    true,
+	"log-file":     true,
+	"log-format":   true,
+	"error-format": true,
+}
+
+// agentEnv are the environment variables that change which credentials are
+// returned or where they are recorded. The agent only serves clients whose
+// values match its own, and compares digests so that secrets such as
+// VAULT_TOKEN do not cross the socket.
+var agentEnv = []string{
+	"AWS_CONFIG_FILE",
+	"AWS_SHARED_CREDENTIALS_FILE",
+	"AWS_REGION",
+	"AWS_DEFAULT_REGION",
+	"AWS_ENDPOINT_URL",
+	"AWS_ENDPOINT_URL_STS",
+	"AWS_CA_BUNDLE",
+	"AWS_MAX_ATTEMPTS",
+	"AWS_RETRY_MODE",
+	"HTTPS_PROXY",
+	"NO_PROXY",
+	"XDG_CACHE_HOME",
+	"OP_ACCOUNT",
+	"OP_AWS_CP_EXPIRY_WINDOW",
+	"OP_AWS_CP_AUDIT_LOG",
+	"BW_SESSION",
+	"PASSWORD_STORE_DIR",
+	"VAULT_ADDR",
+	"VAULT_TOKEN",
+	"VAULT_NAMESPACE",
+	"VAULT_CACERT",
+}
+
+func (c *agentCmd) Run(cli *CLI) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	path := c.Socket
+	if path == "" {
+		var err error
+		path, err = defaultAgentSocket()
+		if err != nil {
+			return err
+		}
+	}
+
+	ln, err := listenAgent(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(path)
+	}()
+
+	srv := newAgentServer(newAgent(newAgentSession))
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "export %s=%s\n", agentSockEnv, path)
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func defaultAgentSocket() (string, error) {
+	if sock := os.Getenv(agentSockEnv); sock != "" {
+		return sock, nil
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		var err error
+		dir, err = sessioncache.DefaultDir()
+		if err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, "op-aws-credential-process", "agent.sock"), nil
+}
+
+func listenAgent(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := checkAgentDir(dir); err != nil {
+		return nil, err
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("agent is already listening on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	ln, err := listenSocket(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// agentRequest asks for the session of Profile issued with Options, which
+// map flag names to their values. Env holds digests of the client's agentEnv.
+type agentRequest struct {
+	Profile string              `json:"profile"`
+	Options map[string][]string `json:"options,omitempty"`
+	Env     map[string]string   `json:"env,omitempty"`
+}
+
+// newAgentRequest builds the request for the flags given on the command
+// line. Flags from the environment are covered by Env instead.
+func newAgentRequest(cli *CLI, kctx *kong.Context) agentRequest {
+	req := agentRequest{
+		Profile: cli.Profile,
+		Options: make(map[string][]string),
+		Env:     make(map[string]string),
+	}
+	for _, path := range kctx.Path {
+		if path.Flag == nil || path.Resolved || agentLocalOptions[path.Flag.Name] {
+			continue
+		}
+		req.Options[path.Flag.Name] = flagStrings(kctx.FlagValue(path.Flag))
+	}
+	for _, name := range agentEnv {
+		req.Env[name] = envDigest(os.Getenv(name))
+	}
+	return req
+}
+
+func flagStrings(v any) []string {
+	switch v := v.(type) {
+	case []string:
+		return v
+	case map[string]string:
+		var out []string
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			out = append(out, k+"="+v[k])
+		}
+		return out
+	case string:
+		return []string{v}
+	case bool:
+		return []string{strconv.FormatBool(v)}
+	}
+	return []string{fmt.Sprint(v)}
+}
+
+func envDigest(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// args validates the request against agentOptions and agentEnv and returns
+// the command line to build the session provider from.
+func (r agentRequest) args() ([]string, error) {
+	for _, name := range agentEnv {
+		if r.Env[name] != envDigest(os.Getenv(name)) {
+			return nil, fmt.Errorf("%s differs from the agent's", name)
+		}
+	}
+
+	args := []string{"--profile=" + r.Profile}
+	for _, name := range slices.Sorted(maps.Keys(r.Options)) {
+		if !agentOptions[name] {
+			return nil, fmt.Errorf("--%s is not accepted by the agent", name)
+		}
+		for _, v := range r.Options[name] {
+			args = append(args, "--"+name+"="+v)
+		}
+	}
+	return args, nil
+}
+
+type agentError struct {
+	Error    string `json:"error"`
+	Type     string `json:"type"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// agentCallerKey is the context key of the audit.Caller behind a connection.
+type agentCallerKey struct{}
+
+// newAgentServer serves a, recording in the context of each connection the
+// process the client was run by, as far as the platform tells.
+func newAgentServer(a *agent) *http.Server {
+	return &http.Server{
+		Handler: a,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			if caller, ok := peerCaller(conn); ok {
+				return context.WithValue(ctx, agentCallerKey{}, caller)
+			}
+			return ctx
+		},
+	}
+}
+
+type agent struct {
+	newSession func(args []string) (*sessioncache.Provider, error)
+
+	mu       sync.Mutex
+	sessions map[string]*agentSession
+}
+
+// agentSession is a session the agent has served, kept so that repeated
+// requests need neither a new provider nor a read of the cache file.
+type agentSession struct {
+	mu       sync.Mutex
+	provider *sessioncache.Provider
+	creds    *ststypes.Credentials
+}
+
+func newAgent(newSession func(args []string) (*sessioncache.Provider, error)) *agent {
+	return &agent{
+		newSession: newSession,
+		sessions:   make(map[string]*agentSession),
+	}
+}
+
+func newAgentSession(args []string) (*sessioncache.Provider, error) {
+	var cli CLI
+	parser, err := kong.New(&cli, append(kongOptions(),
+		kong.Writers(io.Discard, io.Discard),
+		kong.Exit(func(int) {}),
+	)...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parser.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg, err := cli.loadSharedConfig(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := cli.newCachedSessionProvider(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	provider.BackgroundRefresh = func() error {
+		return spawnBackgroundRefresh(refreshLockPath(provider), args)
+	}
+	return provider, nil
+}
+
+// sessionKey identifies the session of a validated request by its
+// arguments and agentEnv digests.
+func sessionKey(args []string, env map[string]string) string {
+	data, _ := json.Marshal(struct {
+		Args []string          `json:"args"`
+		Env  map[string]string `json:"env"`
+	}{args, env})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// session returns the session of key, so concurrent requests for it share
+// one provider and one MFA prompt.
+func (a *agent) session(key string) *agentSession {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, ok := a.sessions[key]
+	if !ok {
+		s = new(agentSession)
+		a.sessions[key] = s
+	}
+	return s
+}
+
+// retrieve serves the credentials kept in memory while they are fresh and
+// otherwise goes through the provider, so that cache hits are audited and
+// start background refreshes as they do without the agent.
+func (a *agent) retrieve(ctx context.Context, key string, args []string) (*ststypes.Credentials, error) {
+	s := a.session(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.provider == nil {
+		provider, err := a.newSession(args)
+		if err != nil {
+			return nil, err
+		}
+		s.provider = provider
+	}
+	// The agent's own parent process is not the one asking.
+	s.provider.Caller, _ = ctx.Value(agentCallerKey{}).(audit.Caller)
+
+	if s.provider.Fresh(s.creds) {
+		s.provider.AuditHit(ctx, s.creds)
+		return s.creds, nil
+	}
+
+	creds, _, err := s.provider.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.creds = creds
+	return creds, nil
+}
+
+func (a *agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/credentials" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req agentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAgentJSON(w, http.StatusBadRequest, agentError{Error: err.Error()})
+		return
+	}
+
+	args, err := req.args()
+	if err != nil {
+		writeAgentJSON(w, http.StatusConflict, agentError{Error: err.Error()})
+		return
+	}
+
+	creds, err := a.retrieve(r.Context(), sessionKey(args, req.Env), args)
+	if err != nil {
+		typ, code := errorType(err)
+		writeAgentJSON(w, http.StatusInternalServerError, agentError{Error: err.Error(), Type: typ, ExitCode: code})
+		return
+	}
+	writeAgentJSON(w, http.StatusOK, credentialProcessResponse(creds))
+}
+
+func writeAgentJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// agentCredentials asks the agent at sock for credentials. It returns
+// errAgentUnavailable when the agent is not running or does not serve the
+// request, so that the caller can retrieve them directly.
+func agentCredentials(ctx context.Context, sock string, r agentRequest) (*processcreds.CredentialProcessResponse, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sock)
+			},
+		},
+	}
+
+	body, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://agent/credentials", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errAgentUnavailable, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		var agentErr agentError
+		if err := json.NewDecoder(resp.Body).Decode(&agentErr); err != nil {
+			return nil, fmt.Errorf("agent returned %s", resp.Status)
+		}
+		if resp.StatusCode == http.StatusConflict {
+			return nil, fmt.Errorf("%w: %s", errAgentUnavailable, agentErr.Error)
+		}
+		return nil, &remoteError{Type: agentErr.Type, Code: agentErr.ExitCode, Message: agentErr.Error}
+	}
+
+	var out processcreds.CredentialProcessResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
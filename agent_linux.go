@@ -0,0 +1,62 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/audit"
+)
+
+// peerCaller returns the parent of the process on the other end of conn,
+// read with SO_PEERCRED: the client is the credential_process run by the
+// tool asking for credentials.
+func peerCaller(conn net.Conn) (audit.Caller, bool) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return audit.Caller{}, false
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return audit.Caller{}, false
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil || credErr != nil {
+		return audit.Caller{}, false
+	}
+
+	ppid, err := parentPID(int(cred.Pid))
+	if err != nil {
+		return audit.Caller{}, false
+	}
+	return audit.ProcessCaller(ppid), true
+}
+
+func parentPID(pid int) (int, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// The command name may contain spaces and parentheses, so the fields
+	// are counted from the last ')': state, then the parent PID.
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return 0, errors.New("malformed /proc stat")
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 2 {
+		return 0, errors.New("malformed /proc stat")
+	}
+	return strconv.Atoi(fields[1])
+}
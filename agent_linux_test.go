@@ -0,0 +1,41 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/audit"
+	"github.com/scizorman/op-aws-credential-process/pkg/sessioncache"
+)
+
+func TestAgent_AuditsPeerCaller(t *testing.T) {
+	auditLog := &audit.Log{Path: filepath.Join(t.TempDir(), "audit.log")}
+	dir := t.TempDir()
+	sock := startTestAgent(t, func(args []string) (*sessioncache.Provider, error) {
+		return &sessioncache.Provider{
+			SessionProvider: &fakeStsSessionProvider{creds: newStsCreds("AGENT_KEY", "AGENT_SECRET", "AGENT_TOKEN", time.Now().Add(1*time.Hour))},
+			CacheDir:        dir,
+			Profile:         "test-profile",
+			ExpiryWindow:    5 * time.Minute,
+			Audit:           auditLog,
+		}, nil
+	})
+
+	if _, err := agentCredentials(context.Background(), sock, parseAgentRequest(t)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	records, err := auditLog.Records()
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	// The test process is the client, so the caller is its parent.
+	if len(records) != 1 || records[0].CallerPID != os.Getppid() {
+		t.Errorf("records = %+v, want CallerPID %d", records, os.Getppid())
+	}
+}
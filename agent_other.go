@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import (
+	"net"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/audit"
+)
+
+// peerCaller is only implemented with SO_PEERCRED on Linux; elsewhere audit
+// records of the agent have no caller.
+func peerCaller(conn net.Conn) (audit.Caller, bool) {
+	return audit.Caller{}, false
+}
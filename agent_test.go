@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/audit"
+	"github.com/scizorman/op-aws-credential-process/pkg/sessioncache"
+)
+
+type slowStsSessionProvider struct {
+	fakeStsSessionProvider
+	delay time.Duration
+}
+
+func (f *slowStsSessionProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
+	time.Sleep(f.delay)
+	return f.fakeStsSessionProvider.RetrieveStsCredentials(ctx)
+}
+
+func startTestAgent(t *testing.T, newSession func(args []string) (*sessioncache.Provider, error)) string {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "agent", "agent.sock")
+	ln, err := listenAgent(sock)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := newAgentServer(newAgent(newSession))
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+	return sock
+}
+
+func parseAgentRequest(t *testing.T, args ...string) agentRequest {
+	t.Helper()
+	var cli CLI
+	parser, err := kong.New(&cli, kongOptions()...)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	kctx, err := parser.Parse(args)
+	if err != nil {
+		t.Fatalf("failed to parse %v: %v", args, err)
+	}
+	return newAgentRequest(&cli, kctx)
+}
+
+func TestAgent_DeduplicatesConcurrentRequests(t *testing.T) {
+	inner := &slowStsSessionProvider{
+		fakeStsSessionProvider: fakeStsSessionProvider{creds: newStsCreds("AGENT_KEY", "AGENT_SECRET", "AGENT_TOKEN", time.Now().Add(1*time.Hour))},
+		delay:                  50 * time.Millisecond,
+	}
+	dir := t.TempDir()
+	var sessions atomic.Int32
+	sock := startTestAgent(t, func(args []string) (*sessioncache.Provider, error) {
+		sessions.Add(1)
+		return &sessioncache.Provider{
+			SessionProvider: inner,
+			CacheDir:        dir,
+			Profile:         "test-profile",
+			ExpiryWindow:    5 * time.Minute,
+			Item:            defaultItem(),
+			MfaSerial:       "mfa-serial",
+		}, nil
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Go(func() {
+			resp, err := agentCredentials(context.Background(), sock, parseAgentRequest(t, "--profile", "test-profile"))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if resp.AccessKeyID != "AGENT_KEY" {
+				errs <- errors.New("unexpected access key " + resp.AccessKeyID)
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if inner.called != 1 {
+		t.Errorf("inner.called = %d, want 1", inner.called)
+	}
+	if got := sessions.Load(); got != 1 {
+		t.Errorf("sessions = %d, want 1", got)
+	}
+}
+
+func TestAgent_ServesCachedSessionThroughProvider(t *testing.T) {
+	inner := &fakeStsSessionProvider{creds: newStsCreds("AGENT_KEY", "AGENT_SECRET", "AGENT_TOKEN", time.Now().Add(1*time.Hour))}
+	auditLog := &audit.Log{Path: filepath.Join(t.TempDir(), "audit.log")}
+	dir := t.TempDir()
+	var sessions int
+	a := newAgent(func(args []string) (*sessioncache.Provider, error) {
+		sessions++
+		return &sessioncache.Provider{
+			SessionProvider: inner,
+			CacheDir:        dir,
+			Profile:         "test-profile",
+			ExpiryWindow:    5 * time.Minute,
+			Item:            defaultItem(),
+			MfaSerial:       "mfa-serial",
+			Audit:           auditLog,
+		}, nil
+	})
+
+	args := []string{"--profile=test-profile"}
+	for range 2 {
+		creds, err := a.retrieve(context.Background(), sessionKey(args, nil), args)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := aws.ToString(creds.AccessKeyId); got != "AGENT_KEY" {
+			t.Errorf("AccessKeyId = %q, want %q", got, "AGENT_KEY")
+		}
+	}
+	if inner.called != 1 {
+		t.Errorf("inner.called = %d, want 1", inner.called)
+	}
+
+	records, err := auditLog.Records()
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	if len(records) != 2 || records[0].CacheHit || !records[1].CacheHit {
+		t.Errorf("records = %+v, want a miss and then a hit", records)
+	}
+	if sessions != 1 {
+		t.Errorf("newSession called %d times, want 1", sessions)
+	}
+}
+
+func TestAgent_RenewsStaleSession(t *testing.T) {
+	inner := &fakeStsSessionProvider{creds: newStsCreds("AGENT_KEY", "AGENT_SECRET", "AGENT_TOKEN", time.Now().Add(1*time.Hour))}
+	dir := t.TempDir()
+	var sessions int
+	a := newAgent(func(args []string) (*sessioncache.Provider, error) {
+		sessions++
+		return &sessioncache.Provider{
+			SessionProvider: inner,
+			CacheDir:        dir,
+			Profile:         "test-profile",
+			ExpiryWindow:    5 * time.Minute,
+			Item:            defaultItem(),
+			MfaSerial:       "mfa-serial",
+		}, nil
+	})
+
+	args := []string{"--profile=test-profile"}
+	if _, err := a.retrieve(context.Background(), sessionKey(args, nil), args); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a.session(sessionKey(args, nil)).provider.Now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	inner.creds = newStsCreds("NEW_KEY", "NEW_SECRET", "NEW_TOKEN", time.Now().Add(3*time.Hour))
+
+	creds, err := a.retrieve(context.Background(), sessionKey(args, nil), args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := aws.ToString(creds.AccessKeyId); got != "NEW_KEY" {
+		t.Errorf("AccessKeyId = %q, want %q", got, "NEW_KEY")
+	}
+	if inner.called != 2 {
+		t.Errorf("inner.called = %d, want 2", inner.called)
+	}
+	if sessions != 1 {
+		t.Errorf("newSession called %d times, want 1", sessions)
+	}
+}
+
+func TestAgent_Error(t *testing.T) {
+	sock := startTestAgent(t, func(args []string) (*sessioncache.Provider, error) {
+		return &sessioncache.Provider{
+			SessionProvider: &fakeStsSessionProvider{err: errors.New("inner error")},
+			CacheDir:        t.TempDir(),
+			Profile:         "test-profile",
+			ExpiryWindow:    5 * time.Minute,
+		}, nil
+	})
+
+	_, err := agentCredentials(context.Background(), sock, parseAgentRequest(t))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if errors.Is(err, errAgentUnavailable) {
+		t.Errorf("error = %v, should not be errAgentUnavailable", err)
+	}
+	if err.Error() != "inner error" {
+		t.Errorf("error = %q, want %q", err.Error(), "inner error")
+	}
+}
+
+func TestAgentCredentials_Unavailable(t *testing.T) {
+	_, err := agentCredentials(context.Background(), filepath.Join(t.TempDir(), "missing.sock"), parseAgentRequest(t))
+	if !errors.Is(err, errAgentUnavailable) {
+		t.Errorf("error = %v, want errAgentUnavailable", err)
+	}
+}
+
+func TestNewAgentSession_ParsesArgs(t *testing.T) {
+	if _, err := newAgentSession([]string{"--unknown-flag"}); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestAgent_DeclinesRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(*agentRequest)
+	}{
+		{"exec path", func(r *agentRequest) { r.Options["op-cli-path"] = []string{"/bin/sh"} }},
+		{"command", func(r *agentRequest) { r.Options["web-identity-token-command"] = []string{"touch /tmp/pwned"} }},
+		{"unknown option", func(r *agentRequest) { r.Options["pinentry-program"] = []string{"/bin/sh"} }},
+		{"environment", func(r *agentRequest) { r.Env["VAULT_ADDR"] = envDigest("https://other.example.com") }},
+		{"missing environment", func(r *agentRequest) { delete(r.Env, "AWS_CONFIG_FILE") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			sock := startTestAgent(t, func(args []string) (*sessioncache.Provider, error) {
+				called = true
+				return nil, errors.New("unexpected session")
+			})
+
+			req := parseAgentRequest(t, "--profile", "test-profile")
+			tt.edit(&req)
+
+			_, err := agentCredentials(context.Background(), sock, req)
+			if !errors.Is(err, errAgentUnavailable) {
+				t.Errorf("error = %v, want errAgentUnavailable", err)
+			}
+			if called {
+				t.Error("session was built for a declined request")
+			}
+		})
+	}
+}
+
+func TestNewAgentRequest(t *testing.T) {
+	t.Setenv("VAULT_TOKEN", "s.secret")
+	req := parseAgentRequest(t, "--profile=dev", "--role-arn", "arn:aws:iam::123456789012:role/r", "--tag", "team=a", "--tag", "env=b", "--federation=false", "--log-level", "debug", "--duration", "1h")
+
+	if req.Profile != "dev" {
+		t.Errorf("Profile = %q, want %q", req.Profile, "dev")
+	}
+	want := map[string][]string{
+		"role-arn":   {"arn:aws:iam::123456789012:role/r"},
+		"tag":        {"env=b", "team=a"},
+		"federation": {"false"},
+		"duration":   {"1h0m0s"},
+	}
+	if len(req.Options) != len(want) {
+		t.Errorf("Options = %v, want %v", req.Options, want)
+	}
+	for name, values := range want {
+		if !slices.Equal(req.Options[name], values) {
+			t.Errorf("Options[%q] = %v, want %v", name, req.Options[name], values)
+		}
+	}
+	if req.Env["VAULT_TOKEN"] != envDigest("s.secret") {
+		t.Errorf("Env[VAULT_TOKEN] = %q, want its digest", req.Env["VAULT_TOKEN"])
+	}
+
+	args, err := req.args()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantArgs := []string{"--profile=dev", "--duration=1h0m0s", "--federation=false", "--role-arn=arn:aws:iam::123456789012:role/r", "--tag=env=b", "--tag=team=a"}
+	if !slices.Equal(args, wantArgs) {
+		t.Errorf("args = %v, want %v", args, wantArgs)
+	}
+}
+
+func TestListenAgent_RefusesSharedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatalf("failed to chmod: %v", err)
+	}
+	if _, err := listenAgent(filepath.Join(dir, "agent.sock")); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestListenAgent_SocketMode(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "agent", "agent.sock")
+	ln, err := listenAgent(sock)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	info, err := os.Stat(sock)
+	if err != nil {
+		t.Fatalf("failed to stat socket: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("mode = %#o, want 0600", perm)
+	}
+}
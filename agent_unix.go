@@ -0,0 +1,34 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenSocket creates the socket with the umask applied, so that it is
+// never reachable by other users before listenAgent restricts its mode.
+func listenSocket(path string) (net.Listener, error) {
+	umask := syscall.Umask(0077)
+	defer syscall.Umask(umask)
+	return net.Listen("unix", path)
+}
+
+// checkAgentDir refuses a socket directory other users can reach, since
+// MkdirAll leaves the mode of an existing directory, e.g. /tmp, as it is.
+func checkAgentDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("agent socket directory %s is not owned by the current user", dir)
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		return fmt.Errorf("agent socket directory %s has mode %#o, want 0700", dir, perm)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package main
+
+import "net"
+
+func listenSocket(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
+
+// checkAgentDir accepts any directory: access on Windows is decided by ACLs,
+// which mode bits do not reflect, and the default socket directory under the
+// user's profile is private to the user.
+func checkAgentDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/audit"
+)
+
+type auditCmd struct {
+	Tail auditTailCmd `cmd:"" help:"Show the most recent audit log records."`
+}
+
+type auditTailCmd struct {
+	Lines         int           `short:"n" default:"20" help:"Number of records to show."`
+	Since         time.Duration `help:"Only show records newer than this."`
+	FilterProfile string        `help:"Only show records for this profile."`
+	Format        string        `default:"table" enum:"table,json" help:"Output format (table, json)."`
+}
+
+func (c *auditTailCmd) Run(cli *CLI) error {
+	if cli.AuditLog == "" {
+		return errors.New("--audit-log is required")
+	}
+
+	records, err := cli.auditLog().Records()
+	if err != nil {
+		return err
+	}
+	records = c.filter(records, time.Now())
+	return writeAuditRecords(os.Stdout, records, c.Format)
+}
+
+func (c *auditTailCmd) filter(records []audit.Record, now time.Time) []audit.Record {
+	var filtered []audit.Record
+	for _, rec := range records {
+		if c.Since > 0 && rec.Time.Before(now.Add(-c.Since)) {
+			continue
+		}
+		if c.FilterProfile != "" && rec.Profile != c.FilterProfile {
+			continue
+		}
+		filtered = append(filtered, rec)
+	}
+	if c.Lines > 0 && len(filtered) > c.Lines {
+		filtered = filtered[len(filtered)-c.Lines:]
+	}
+	return filtered
+}
+
+// callerCommand marks the command of callers that did not ask for the
+// credentials themselves.
+func callerCommand(rec audit.Record) string {
+	if rec.CallerKind == "" {
+		return rec.CallerCommand
+	}
+	return "[" + rec.CallerKind + "] " + rec.CallerCommand
+}
+
+func writeAuditRecords(w io.Writer, records []audit.Record, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		for _, rec := range records {
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tPROFILE\tOPERATION\tACCESS KEY\tEXPIRATION\tCACHE\tPID\tCOMMAND")
+	for _, rec := range records {
+		cache := "miss"
+		if rec.CacheHit {
+			cache = "hit"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			rec.Time.Local().Format(time.RFC3339),
+			rec.Profile,
+			rec.Operation,
+			rec.AccessKeyIDPrefix,
+			rec.Expiration.Local().Format(time.RFC3339),
+			cache,
+			rec.CallerPID,
+			callerCommand(rec),
+		)
+	}
+	return tw.Flush()
+}
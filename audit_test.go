@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/audit"
+)
+
+func TestAuditTailCmd_Filter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	records := []audit.Record{
+		{Time: now.Add(-3 * time.Hour), Profile: "a"},
+		{Time: now.Add(-30 * time.Minute), Profile: "b"},
+		{Time: now.Add(-20 * time.Minute), Profile: "a"},
+		{Time: now.Add(-10 * time.Minute), Profile: "a"},
+	}
+
+	cmd := &auditTailCmd{Lines: 1, Since: time.Hour, FilterProfile: "a"}
+	got := cmd.filter(records, now)
+	if len(got) != 1 || !got[0].Time.Equal(now.Add(-10*time.Minute)) {
+		t.Errorf("filter = %+v, want the last record for profile a", got)
+	}
+
+	cmd = &auditTailCmd{Since: time.Hour}
+	if got := cmd.filter(records, now); len(got) != 3 {
+		t.Errorf("len(filter) = %d, want 3", len(got))
+	}
+}
+
+func TestWriteAuditRecords_Table(t *testing.T) {
+	var buf bytes.Buffer
+	records := []audit.Record{{Profile: "dev", Operation: "GetSessionToken", CacheHit: true, CallerPID: 42, CallerCommand: "aws s3 ls"}}
+	if err := writeAuditRecords(&buf, records, "table"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"dev", "GetSessionToken", "hit", "42", "aws s3 ls"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output %q does not contain %q", buf.String(), want)
+		}
+	}
+}
+
+func TestWriteAuditRecords_TableCallerKind(t *testing.T) {
+	var buf bytes.Buffer
+	records := []audit.Record{{Profile: "dev", CallerKind: audit.CallerBackgroundRefresh, CallerPID: 42, CallerCommand: "aws s3 ls"}}
+	if err := writeAuditRecords(&buf, records, "table"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "[background-refresh] aws s3 ls"; !strings.Contains(buf.String(), want) {
+		t.Errorf("output %q does not contain %q", buf.String(), want)
+	}
+}
+
+func TestCLI_RejectsNegativeAuditMaxBackups(t *testing.T) {
+	var cli CLI
+	parser, err := kong.New(&cli, kongOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parser.Parse([]string{"--profile", "dev", "--audit-max-backups=-1"})
+	if err == nil || !strings.Contains(err.Error(), "must not be negative") {
+		t.Errorf("err = %v, want --audit-max-backups rejected", err)
+	}
+}
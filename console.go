@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+const defaultFederationEndpoint = "https://signin.aws.amazon.com/federation"
+
+type consoleCmd struct {
+	Destination        string `default:"/console/home" help:"Console path to open after signing in."`
+	Region             string `help:"Console region. Defaults to the profile region."`
+	Open               bool   `help:"Open the sign-in URL in a browser instead of printing it."`
+	FederationEndpoint string `default:"${federation_endpoint}" help:"AWS sign-in federation endpoint."`
+}
+
+func (c *consoleCmd) Run(cli *CLI) error {
+	ctx := context.Background()
+
+	cfg, err := cli.loadSharedConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	creds, err := c.consoleCredentials(ctx, cli, cfg)
+	if err != nil {
+		return err
+	}
+
+	client, err := cli.httpClient(ctx)
+	if err != nil {
+		return err
+	}
+	token, err := signinToken(ctx, client, c.FederationEndpoint, creds)
+	if err != nil {
+		return err
+	}
+
+	region := c.Region
+	if region == "" {
+		region = cfg.Region
+	}
+	destination, err := consoleDestination(region, c.Destination)
+	if err != nil {
+		return err
+	}
+	loginURL, err := consoleLoginURL(c.FederationEndpoint, token, destination)
+	if err != nil {
+		return err
+	}
+
+	if c.Open {
+		return openBrowser(loginURL)
+	}
+	_, err = fmt.Fprintln(os.Stdout, loginURL)
+	return err
+}
+
+// The federation endpoint only accepts role or federated user sessions, so
+// without a role GetFederationToken is called instead of GetSessionToken.
+func (c *consoleCmd) consoleCredentials(ctx context.Context, cli *CLI, cfg config.SharedConfig) (*ststypes.Credentials, error) {
+	if _, ok := cli.webIdentityToken(cfg); !ok && cli.RoleArn == "" {
+		cli.Federation = true
+	}
+	source, err := cli.newCachedSessionProvider(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return source.RetrieveStsCredentials(ctx)
+}
+
+func signinToken(ctx context.Context, client aws.HTTPClient, endpoint string, creds *ststypes.Credentials) (string, error) {
+	session, err := json.Marshal(map[string]string{
+		"sessionId":    aws.ToString(creds.AccessKeyId),
+		"sessionKey":   aws.ToString(creds.SecretAccessKey),
+		"sessionToken": aws.ToString(creds.SessionToken),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery = url.Values{
+		"Action":  {"getSigninToken"},
+		"Session": {string(session)},
+	}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("federation endpoint returned %s", resp.Status)
+	}
+
+	var out struct {
+		SigninToken string `json:"SigninToken"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	if out.SigninToken == "" {
+		return "", errors.New("federation endpoint returned an empty sign-in token")
+	}
+
+	return out.SigninToken, nil
+}
+
+func consoleDestination(region, destination string) (string, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = "https"
+	u.Host = "console.aws.amazon.com"
+	if !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path
+	}
+	if region != "" {
+		u.Host = region + ".console.aws.amazon.com"
+		q := u.Query()
+		if !q.Has("region") {
+			q.Set("region", region)
+			u.RawQuery = q.Encode()
+		}
+	}
+	return u.String(), nil
+}
+
+func consoleLoginURL(endpoint, token, destination string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery = url.Values{
+		"Action":      {"login"},
+		"Issuer":      {"op-aws-credential-process"},
+		"Destination": {destination},
+		"SigninToken": {token},
+	}.Encode()
+	return u.String(), nil
+}
+
+func openBrowser(target string) error {
+	name := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		name = "open"
+	}
+	return exec.Command(name, target).Run()
+}
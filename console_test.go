@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSigninToken(t *testing.T) {
+	var session map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("Action"); got != "getSigninToken" {
+			t.Errorf("Action = %q, want %q", got, "getSigninToken")
+		}
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("Session")), &session); err != nil {
+			t.Errorf("failed to unmarshal Session: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"SigninToken":"SIGNIN_TOKEN"}`))
+	}))
+	defer srv.Close()
+
+	token, err := signinToken(context.Background(), srv.Client(), srv.URL+"/federation", newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token != "SIGNIN_TOKEN" {
+		t.Errorf("token = %q, want %q", token, "SIGNIN_TOKEN")
+	}
+	want := map[string]string{"sessionId": "ASIA", "sessionKey": "SECRET", "sessionToken": "TOKEN"}
+	for k, v := range want {
+		if session[k] != v {
+			t.Errorf("Session[%q] = %q, want %q", k, session[k], v)
+		}
+	}
+}
+
+func TestCLI_HTTPClient_CABundle(t *testing.T) {
+	args := setupSTSTest(t, "[profile e2e]\nregion = us-east-1\n")
+	server := newFakeSTSTLSServer(t)
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"SigninToken":"SIGNIN_TOKEN"}`))
+	})
+	creds := newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(time.Hour))
+
+	client, err := parseCLI(t, args).httpClient(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := signinToken(context.Background(), client, server.URL, creds); err == nil {
+		t.Fatal("expected an untrusted certificate error, got nil")
+	}
+
+	client, err = parseCLI(t, append(args, "--ca-bundle", server.writeCABundle(t))).httpClient(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := signinToken(context.Background(), client, server.URL, creds); err != nil {
+		t.Errorf("unexpected error with --ca-bundle: %v", err)
+	}
+}
+
+func TestSigninToken_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad credentials", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	_, err := signinToken(context.Background(), srv.Client(), srv.URL, newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(time.Hour)))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestConsoleLoginURL(t *testing.T) {
+	got, err := consoleLoginURL("https://signin.aws.amazon.com/federation", "SIGNIN_TOKEN", "https://console.aws.amazon.com/console/home")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	u, err := url.Parse(got)
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	q := u.Query()
+	if q.Get("Action") != "login" {
+		t.Errorf("Action = %q, want %q", q.Get("Action"), "login")
+	}
+	if q.Get("SigninToken") != "SIGNIN_TOKEN" {
+		t.Errorf("SigninToken = %q, want %q", q.Get("SigninToken"), "SIGNIN_TOKEN")
+	}
+	if q.Get("Destination") != "https://console.aws.amazon.com/console/home" {
+		t.Errorf("Destination = %q, want %q", q.Get("Destination"), "https://console.aws.amazon.com/console/home")
+	}
+}
+
+func TestConsoleDestination(t *testing.T) {
+	tests := []struct {
+		region      string
+		destination string
+		want        string
+	}{
+		{"", "/console/home", "https://console.aws.amazon.com/console/home"},
+		{"ap-northeast-1", "/console/home", "https://ap-northeast-1.console.aws.amazon.com/console/home?region=ap-northeast-1"},
+		{"ap-northeast-1", "s3/home?region=us-east-1", "https://ap-northeast-1.console.aws.amazon.com/s3/home?region=us-east-1"},
+	}
+	for _, tt := range tests {
+		got, err := consoleDestination(tt.region, tt.destination)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("consoleDestination(%q, %q) = %q, want %q", tt.region, tt.destination, got, tt.want)
+		}
+	}
+}
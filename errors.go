@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+	"github.com/scizorman/op-aws-credential-process/pkg/otp"
+	"github.com/scizorman/op-aws-credential-process/pkg/sessioncache"
+	"github.com/scizorman/op-aws-credential-process/pkg/stssession"
+)
+
+const (
+	exitGeneric         = 1
+	exitMFACancelled    = 3
+	exitOTP             = 4
+	exitBackend         = 5
+	exitSTS             = 6
+	exitSTSAccessDenied = 7
+	exitCache           = 8
+	exitMFADevice       = 9
+	exitWebIdentity     = 10
+)
+
+// remoteError carries an error classification across the agent socket.
+type remoteError struct {
+	Type    string
+	Code    int
+	Message string
+}
+
+func (e *remoteError) Error() string {
+	return e.Message
+}
+
+// errorType classifies err for exit codes and JSON output. A secret store
+// failure, including one while fetching an OTP, is reported under the
+// backend's name (op, bw, ...), since that is what the user has to fix.
+func errorType(err error) (string, int) {
+	if remoteErr, ok := errors.AsType[*remoteError](err); ok {
+		return remoteErr.Type, remoteErr.Code
+	}
+	if errors.Is(err, otp.ErrCancelled) {
+		return "mfa_cancelled", exitMFACancelled
+	}
+	if backendErr, ok := errors.AsType[*backend.Error](err); ok {
+		return backendErr.Backend, exitBackend
+	}
+	if stsErr, ok := errors.AsType[*stssession.Error](err); ok {
+		if stsErr.ErrorCode() == "AccessDenied" {
+			return "sts_access_denied", exitSTSAccessDenied
+		}
+		return "sts", exitSTS
+	}
+	if _, ok := errors.AsType[*stssession.OTPError](err); ok {
+		return "otp", exitOTP
+	}
+	if _, ok := errors.AsType[*sessioncache.Error](err); ok {
+		return "cache", exitCache
+	}
+	if _, ok := errors.AsType[*stssession.MFADeviceError](err); ok {
+		return "mfa_device", exitMFADevice
+	}
+	if _, ok := errors.AsType[*stssession.TokenError](err); ok {
+		return "web_identity_token", exitWebIdentity
+	}
+	return "error", exitGeneric
+}
+
+type errorObject struct {
+	Type      string `json:"type"`
+	ExitCode  int    `json:"exit_code"`
+	Message   string `json:"message"`
+	Operation string `json:"operation,omitempty"`
+	AWSCode   string `json:"aws_error_code,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func newErrorObject(err error) errorObject {
+	typ, code := errorType(err)
+	obj := errorObject{
+		Type:     typ,
+		ExitCode: code,
+		Message:  err.Error(),
+	}
+	if stsErr, ok := errors.AsType[*stssession.Error](err); ok {
+		obj.Operation = stsErr.Operation
+		obj.AWSCode = stsErr.ErrorCode()
+		obj.RequestID = stssession.RequestID(err)
+	}
+	return obj
+}
+
+func writeError(w io.Writer, err error, format string) {
+	if format == "json" {
+		_ = json.NewEncoder(w).Encode(struct {
+			Error errorObject `json:"error"`
+		}{newErrorObject(err)})
+		return
+	}
+	fmt.Fprintln(w, err)
+}
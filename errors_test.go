@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/smithy-go"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+	"github.com/scizorman/op-aws-credential-process/pkg/otp"
+	"github.com/scizorman/op-aws-credential-process/pkg/sessioncache"
+	"github.com/scizorman/op-aws-credential-process/pkg/stssession"
+)
+
+func TestErrorType(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantType string
+		wantCode int
+	}{
+		{"generic", errors.New("boom"), "error", exitGeneric},
+		{"cancelled", &stssession.OTPError{Err: otp.ErrCancelled}, "mfa_cancelled", exitMFACancelled},
+		{"otp", &stssession.OTPError{Err: errors.New("bad input")}, "otp", exitOTP},
+		{"op", &backend.Error{Backend: "op", Command: "item get", Err: errors.New("exit status 1")}, "op", exitBackend},
+		{"op while fetching otp", &stssession.OTPError{Err: &backend.Error{Backend: "op", Command: "item get --otp", Err: errors.New("locked")}}, "op", exitBackend},
+		{"bw", &backend.Error{Backend: "bw", Command: "get item", Err: errors.New("exit status 1")}, "bw", exitBackend},
+		{"bw", &backend.Error{Backend: "bw", Command: "get item", Err: errors.New("exit status 1")}, "bw", exitBackend},
+		{"sts", &stssession.Error{Operation: "GetSessionToken", Err: errors.New("timeout")}, "sts", exitSTS},
+		{"sts access denied", &stssession.Error{Operation: "GetSessionToken", Err: &smithy.GenericAPIError{Code: "AccessDenied"}}, "sts_access_denied", exitSTSAccessDenied},
+		{"cache", fmt.Errorf("wrapped: %w", &sessioncache.Error{Err: errors.New("no home")}), "cache", exitCache},
+		{"mfa device", &stssession.MFADeviceError{Serial: "arn:aws:iam::123456789012:u2f/user/key"}, "mfa_device", exitMFADevice},
+		{"web identity token", &stssession.TokenError{Err: errors.New("no such file")}, "web_identity_token", exitWebIdentity},
+		{"remote", &remoteError{Type: "op", Code: exitBackend, Message: "op item get: locked"}, "op", exitBackend},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ, code := errorType(tt.err)
+			if typ != tt.wantType || code != tt.wantCode {
+				t.Errorf("errorType = (%q, %d), want (%q, %d)", typ, code, tt.wantType, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestWriteError_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	err := &stssession.Error{Operation: "AssumeRole", Err: &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized"}}
+	writeError(&buf, err, "json")
+
+	var got struct {
+		Error errorObject `json:"error"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("failed to unmarshal output %q: %v", buf.String(), err)
+	}
+	want := errorObject{
+		Type:      "sts_access_denied",
+		ExitCode:  exitSTSAccessDenied,
+		Message:   err.Error(),
+		Operation: "AssumeRole",
+		AWSCode:   "AccessDenied",
+	}
+	if got.Error != want {
+		t.Errorf("error object = %+v, want %+v", got.Error, want)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"os/user"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+	"github.com/scizorman/op-aws-credential-process/pkg/sessioncache"
+	"github.com/scizorman/op-aws-credential-process/pkg/stssession"
+)
+
+func (cli *CLI) validateFederation() error {
+	if !cli.Federation {
+		if cli.PolicyFile != "" || len(cli.PolicyArn) > 0 {
+			return errors.New("--policy-file and --policy-arn require --federation")
+		}
+		return nil
+	}
+	if cli.RoleArn != "" {
+		return errors.New("--federation cannot be used with --role-arn")
+	}
+	if cli.PolicyFile == "" && len(cli.PolicyArn) == 0 {
+		return errors.New("--policy-file or --policy-arn is required for GetFederationToken; a session without policies has no permissions")
+	}
+	return nil
+}
+
+// newFederationSessionProvider calls GetFederationToken with the base
+// credentials, scoped down by the session policies.
+func (cli *CLI) newFederationSessionProvider(client stssession.GetFederationTokenAPIClient, base backend.Backend, baseCreds aws.CredentialsProvider) (*sessioncache.Provider, error) {
+	policy, err := readPolicyFile(cli.PolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	name, err := cli.federationName()
+	if err != nil {
+		return nil, err
+	}
+
+	sessionProvider := stssession.NewFederationTokenProvider(client, baseCreds, name, func(p *stssession.FederationTokenProvider) {
+		p.Duration = cli.Duration
+		p.Policy = policy
+		p.PolicyArns = cli.PolicyArn
+	})
+
+	return cli.cacheSession(sessionProvider, func(c *sessioncache.Provider) {
+		c.Backend = base.Name()
+		c.Item = cli.backendItem()
+		c.FederationName = name
+		c.Policy = policy
+		c.PolicyArns = cli.PolicyArn
+	})
+}
+
+// federationName defaults to the OS user name, fitted into what STS accepts.
+func (cli *CLI) federationName() (string, error) {
+	if cli.FederationName != "" {
+		return cli.FederationName, nil
+	}
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return sanitizeSTSName(u.Username, maxFederationNameLen), nil
+}
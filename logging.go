@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/audit"
+)
+
+const redacted = "[REDACTED]"
+
+var sensitiveLogKeys = map[string]bool{
+	"secret_access_key": true,
+	"session_token":     true,
+	"otp":               true,
+	"token_code":        true,
+	"signin_token":      true,
+}
+
+func newLogger(w io.Writer, level, format string) *slog.Logger {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelWarn
+	}
+	opts := &slog.HandlerOptions{
+		Level:       lvl,
+		ReplaceAttr: redactAttr,
+	}
+
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// redactAttr is the last line of defence against secrets reaching the log;
+// callers are still expected not to log them.
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	switch {
+	case sensitiveLogKeys[a.Key]:
+		return slog.String(a.Key, redacted)
+	case a.Key == "access_key_id":
+		return slog.String(a.Key, audit.AccessKeyIDPrefix(a.Value.String()))
+	}
+	return a
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger_RedactsSecrets(t *testing.T) {
+	for _, format := range []string{"text", "json"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := newLogger(&buf, "debug", format)
+			logger.Info("issued",
+				"access_key_id", "ASIAEXAMPLEKEY12345",
+				"secret_access_key", "SECRET_VALUE",
+				"session_token", "TOKEN_VALUE",
+				"otp", "123456",
+			)
+
+			out := buf.String()
+			for _, secret := range []string{"EXAMPLEKEY12345", "SECRET_VALUE", "TOKEN_VALUE", "123456"} {
+				if strings.Contains(out, secret) {
+					t.Errorf("log output %q contains %q", out, secret)
+				}
+			}
+			if !strings.Contains(out, "ASIAEXAM...") {
+				t.Errorf("log output %q does not contain access key ID prefix", out)
+			}
+		})
+	}
+}
+
+func TestNewLogger_Level(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, "warn", "text")
+	logger.Info("info message")
+	logger.Warn("warn message")
+
+	if strings.Contains(buf.String(), "info message") {
+		t.Errorf("log output %q contains info message at warn level", buf.String())
+	}
+	if !strings.Contains(buf.String(), "warn message") {
+		t.Errorf("log output %q does not contain warn message", buf.String())
+	}
+}
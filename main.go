@@ -3,120 +3,458 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials/processcreds"
-	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/audit"
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+	"github.com/scizorman/op-aws-credential-process/pkg/bwcreds"
+	"github.com/scizorman/op-aws-credential-process/pkg/opcreds"
+	"github.com/scizorman/op-aws-credential-process/pkg/otp"
+	"github.com/scizorman/op-aws-credential-process/pkg/passcreds"
+	"github.com/scizorman/op-aws-credential-process/pkg/sessioncache"
+	"github.com/scizorman/op-aws-credential-process/pkg/stssession"
+	"github.com/scizorman/op-aws-credential-process/pkg/vaultcreds"
 )
 
 var version = "dev"
 
-var cli struct {
-	Profile                string           `default:"default" help:"AWS config profile name."`
-	Duration               time.Duration    `default:"12h" help:"STS session duration."`
-	OpVault                string           `required:"" help:"1Password vault name."`
-	OpItem                 string           `required:"" help:"1Password item name."`
-	OpAccessKeyIDField     string           `default:"Access key ID" help:"1Password field name for access key ID." name:"op-access-key-id-field"`
-	OpSecretAccessKeyField string           `default:"Secret access key" help:"1Password field name for secret access key." name:"op-secret-access-key-field"`
-	OpCLIPath              string           `default:"op" help:"Path to 1Password CLI." name:"op-cli-path"`
-	Version                kong.VersionFlag `help:"Show version."`
+type CLI struct {
+	Profile                   string            `default:"default" help:"AWS config profile name."`
+	Duration                  time.Duration     `default:"12h" help:"STS session duration."`
+	ExpiryWindow              time.Duration     `default:"5m" env:"OP_AWS_CP_EXPIRY_WINDOW" help:"Treat cached sessions as expired this long before they actually expire."`
+	Backend                   string            `default:"op" enum:"op,bw,pass,vault" help:"Secret store holding the access key (op, bw, pass, vault)."`
+	OpVault                   string            `help:"1Password vault name. Required with --backend op, except for agent."`
+	OpItem                    string            `help:"1Password item name. Required with --backend op, except for agent."`
+	OpAccessKeyIDField        string            `default:"Access key ID" help:"1Password field name for access key ID." name:"op-access-key-id-field"`
+	OpSecretAccessKeyField    string            `default:"Secret access key" help:"1Password field name for secret access key." name:"op-secret-access-key-field"`
+	OpTOTPSecretField         string            `default:"TOTP secret" help:"1Password field holding a base32 TOTP secret or otpauth:// URI, used with --mfa-source op-totp." name:"op-totp-secret-field"`
+	OpCLIPath                 string            `default:"op" help:"Path to 1Password CLI." name:"op-cli-path"`
+	BwItem                    string            `help:"Bitwarden item ID or name. Required with --backend bw, except for agent." name:"bw-item"`
+	BwAccessKeyIDField        string            `default:"Access key ID" help:"Bitwarden field name for access key ID; username reads the login username." name:"bw-access-key-id-field"`
+	BwSecretAccessKeyField    string            `default:"Secret access key" help:"Bitwarden field name for secret access key; password reads the login password." name:"bw-secret-access-key-field"`
+	BwCLIPath                 string            `default:"bw" help:"Path to Bitwarden CLI." name:"bw-cli-path"`
+	PassEntry                 string            `help:"pass entry holding the access key. Required with --backend pass, except for agent."`
+	PassAccessKeyIDField      string            `default:"Access key ID" help:"Key of the pass entry line holding the access key ID; password reads the first line." name:"pass-access-key-id-field"`
+	PassSecretAccessKeyField  string            `default:"password" help:"Key of the pass entry line holding the secret access key; password reads the first line." name:"pass-secret-access-key-field"`
+	PassCLIPath               string            `default:"pass" help:"Path to pass, or to gopass." name:"pass-cli-path"`
+	VaultAddr                 string            `env:"VAULT_ADDR" help:"Vault server address. Required with --backend vault."`
+	VaultMount                string            `default:"secret" help:"Mount path of the Vault KV v2 secrets engine."`
+	VaultPath                 string            `help:"Path of the Vault secret holding the access key. Required with --backend vault, except for agent."`
+	VaultAccessKeyIDField     string            `default:"access_key_id" help:"Key of the Vault secret holding the access key ID." name:"vault-access-key-id-field"`
+	VaultSecretAccessKeyField string            `default:"secret_access_key" help:"Key of the Vault secret holding the secret access key." name:"vault-secret-access-key-field"`
+	VaultTokenFile            string            `help:"File holding the Vault token, read when VAULT_TOKEN is not set. Defaults to ~/.vault-token." type:"path"`
+	VaultNamespace            string            `env:"VAULT_NAMESPACE" help:"Vault Enterprise namespace of the secret."`
+	VaultCaCert               string            `env:"VAULT_CACERT" help:"PEM file of the CA certificates to trust for the Vault server." name:"vault-ca-cert" type:"path"`
+	CaBundle                  string            `help:"PEM file of the CA certificates to trust for STS and IAM, overriding ca_bundle and AWS_CA_BUNDLE from the profile." type:"path"`
+	StsTimeout                time.Duration     `default:"0s" help:"Give up on each STS or IAM request after this long. Disabled when 0." name:"sts-timeout"`
+	StsEndpoint               string            `help:"URL of the STS endpoint, overriding endpoint_url and AWS_ENDPOINT_URL_STS from the profile." name:"sts-endpoint"`
+	RoleArn                   string            `help:"IAM role ARN to assume with MFA instead of calling GetSessionToken." name:"role-arn"`
+	RoleSessionName           string            `default:"op-aws-credential-process" help:"Session name used when assuming a role."`
+	Tag                       map[string]string `help:"Session tag (key=value) set when assuming a role. Repeatable." name:"tag"`
+	TransitiveTagKey          []string          `help:"Key of a --tag that carries over to roles assumed from the session. Repeatable." name:"transitive-tag-key"`
+	SourceIdentity            string            `help:"Source identity set when assuming a role; auto uses the signed-in account of the backend, or the OS user name for backends without accounts." name:"source-identity"`
+	SessionPolicyFile         string            `help:"File holding an inline session policy in JSON that scopes down the role session." name:"session-policy-file" type:"path"`
+	SessionPolicyArn          []string          `help:"Managed policy ARN scoping down the role session. Repeatable." name:"session-policy-arn"`
+	Federation                bool              `help:"Call GetFederationToken with the base credentials instead of GetSessionToken, for a session scoped down by --policy-file and --policy-arn. MFA is not used." name:"federation"`
+	FederationName            string            `help:"Federated user name for GetFederationToken. Defaults to the OS user name." name:"federation-name"`
+	PolicyFile                string            `help:"File holding an inline session policy in JSON for GetFederationToken." name:"policy-file" type:"path"`
+	PolicyArn                 []string          `help:"Managed policy ARN scoping the GetFederationToken session. Repeatable." name:"policy-arn"`
+	WebIdentityTokenFile      string            `help:"File holding an OIDC token to assume --role-arn with AssumeRoleWithWebIdentity instead of using the backend. Overrides web_identity_token_file in the profile." name:"web-identity-token-file" type:"path" xor:"web-identity-token"`
+	WebIdentityTokenCommand   string            `help:"Shell command printing an OIDC token to assume --role-arn with AssumeRoleWithWebIdentity instead of using the backend." name:"web-identity-token-command" xor:"web-identity-token"`
+	MfaSerial                 string            `help:"MFA device serial number or ARN. Overrides mfa_serial in the profile." name:"mfa-serial"`
+	MfaSource                 string            `default:"auto" enum:"auto,tty,pinentry,backend,op,op-totp,ykman" help:"Where to read the MFA code from (auto, tty, pinentry, backend, op, op-totp, ykman). auto uses the terminal, or pinentry when there is none; backend reads the TOTP of the --backend item." name:"mfa-source"`
+	MfaRetries                int               `default:"2" help:"How many times to ask for a new MFA code when STS rejects it." name:"mfa-retries"`
+	PinentryProgram           string            `default:"pinentry" help:"pinentry program used to ask for the MFA code without a terminal."`
+	YkmanPath                 string            `default:"ykman" help:"Path to the YubiKey Manager CLI."`
+	YkmanAccount              string            `help:"OATH account name on the YubiKey. Required with --mfa-source ykman."`
+	MfaTimeout                time.Duration     `default:"0s" help:"Give up waiting for an MFA code typed on the terminal after this long. Disabled when 0." name:"mfa-timeout"`
+	RefreshWindow             time.Duration     `default:"0s" help:"Refresh a cached session in the background when it expires within this window, reading the MFA code without a prompt. Disabled when 0."`
+	BackgroundRefresh         string            `hidden:"" help:"Refresh lock held by this background refresh."`
+	BackgroundRefreshCaller   int               `hidden:"" help:"PID of the process that started this background refresh."`
+	LogLevel                  string            `default:"warn" enum:"debug,info,warn,error" help:"Log level (debug, info, warn, error)."`
+	LogFile                   string            `help:"Append logs to this file instead of stderr." type:"path"`
+	LogFormat                 string            `default:"text" enum:"text,json" help:"Log format (text, json)."`
+	AuditLog                  string            `env:"OP_AWS_CP_AUDIT_LOG" help:"Append a JSON-lines audit record to this file whenever credentials are returned." type:"path"`
+	AuditMaxSizeMB            int               `default:"10" help:"Rotate the audit log when it exceeds this size in MiB." name:"audit-max-size-mb"`
+	AuditMaxBackups           int               `default:"5" help:"Number of rotated audit logs to keep. With 0 the log is never rotated."`
+	ErrorFormat               string            `default:"text" enum:"text,json" help:"Format of errors printed on stderr (text, json)."`
+	Version                   kong.VersionFlag  `help:"Show version."`
+
+	Process processCmd `cmd:"" default:"withargs" help:"Print credentials in credential_process format (default)."`
+	Whoami  whoamiCmd  `cmd:"" help:"Show the identity behind the profile."`
+	Console consoleCmd `cmd:"" help:"Print or open an AWS Management Console sign-in URL."`
+	Agent   agentCmd   `cmd:"" help:"Serve credentials over a Unix socket, sharing MFA prompts between callers."`
+	Audit   auditCmd   `cmd:"" help:"Query the audit log."`
 }
 
-type OpAwsItem struct {
-	Vault                string
-	Item                 string
-	AccessKeyIDField     string
-	SecretAccessKeyField string
+// Validate rejects flag values kong's types cannot rule out.
+func (cli *CLI) Validate() error {
+	if cli.AuditMaxBackups < 0 {
+		return fmt.Errorf("--audit-max-backups %d must not be negative", cli.AuditMaxBackups)
+	}
+	return nil
 }
 
-func main() {
-	kong.Parse(&cli,
+func kongOptions() []kong.Option {
+	return []kong.Option{
 		kong.Name("op-aws-credential-process"),
 		kong.Description("AWS credential_process implementation that retrieves credentials from 1Password with MFA session caching"),
-		kong.Vars{"version": version},
-	)
-
-	if err := run(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		kong.Vars{
+			"version":             version,
+			"federation_endpoint": defaultFederationEndpoint,
+		},
 	}
 }
 
-func run() error {
-	ctx := context.Background()
+func main() {
+	var cli CLI
+	kctx := kong.Parse(&cli, kongOptions()...)
 
-	cfg, err := config.LoadSharedConfigProfile(ctx, cli.Profile)
+	closeLog, err := cli.setupLogging()
 	if err != nil {
-		return err
+		writeError(os.Stderr, err, cli.ErrorFormat)
+		os.Exit(exitGeneric)
 	}
+	defer closeLog()
 
-	opCLISource := &opCLICredentialSource{
-		cliPath: cli.OpCLIPath,
-		OpAwsItem: OpAwsItem{
-			Vault:                cli.OpVault,
-			Item:                 cli.OpItem,
-			AccessKeyIDField:     cli.OpAccessKeyIDField,
-			SecretAccessKeyField: cli.OpSecretAccessKeyField,
-		},
+	if cli.BackgroundRefresh != "" {
+		err = cli.backgroundRefresh()
+	} else {
+		err = kctx.Run(&cli)
+	}
+	if err != nil {
+		typ, code := errorType(err)
+		slog.Debug("command failed", "type", typ, "exit_code", code, "error", err)
+		closeLog()
+		writeError(os.Stderr, err, cli.ErrorFormat)
+		os.Exit(code)
 	}
+}
 
-	cachedCreds := aws.NewCredentialsCache(opCLISource)
+func (cli *CLI) setupLogging() (func(), error) {
+	if cli.LogFile == "" {
+		slog.SetDefault(newLogger(os.Stderr, cli.LogLevel, cli.LogFormat))
+		return func() {}, nil
+	}
 
-	stsClient := sts.New(sts.Options{
-		Region:      cfg.Region,
-		Credentials: cachedCreds,
-	})
+	f, err := os.OpenFile(cli.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(newLogger(f, cli.LogLevel, cli.LogFormat))
+	return func() {
+		_ = f.Close()
+	}, nil
+}
 
-	dir, err := cacheDir()
+type processCmd struct{}
+
+func (c *processCmd) Run(cli *CLI, kctx *kong.Context) error {
+	ctx := context.Background()
+
+	if sock := os.Getenv(agentSockEnv); sock != "" {
+		resp, err := agentCredentials(ctx, sock, newAgentRequest(cli, kctx))
+		if err == nil {
+			slog.InfoContext(ctx, "credentials issued by agent", "socket", sock, "access_key_id", resp.AccessKeyID)
+			return json.NewEncoder(os.Stdout).Encode(resp)
+		}
+		if !errors.Is(err, errAgentUnavailable) {
+			return err
+		}
+		slog.DebugContext(ctx, "agent unavailable; retrieving directly", "socket", sock, "error", err)
+	}
+
+	start := time.Now()
+	cfg, err := cli.loadSharedConfig(ctx)
 	if err != nil {
 		return err
 	}
+	slog.DebugContext(ctx, "loaded profile", "profile", cli.Profile, "region", cfg.Region, "mfa_serial", cli.mfaSerial(cfg))
 
-	source := &CachedSessionProvider{
-		SessionProvider: &SessionTokenProvider{
-			BaseCredsProvider: cachedCreds,
-			OTPSource:         &ttyOTPSource{},
-			StsClient:         stsClient,
-			MfaSerial:         cfg.MFASerial,
-			Duration:          cli.Duration,
-		},
-		CacheDir:     dir,
-		Profile:      cli.Profile,
-		ExpiryWindow: expiryWindow,
-		OpAwsItem:    opCLISource.OpAwsItem,
-		MfaSerial:    cfg.MFASerial,
+	source, err := cli.newCachedSessionProvider(ctx, cfg)
+	if err != nil {
+		return err
 	}
 
-	creds, err := source.RetrieveStsCredentials(ctx)
+	creds, cached, err := source.Fetch(ctx)
 	if err != nil {
 		return err
 	}
+	slog.InfoContext(ctx, "credentials issued", "profile", cli.Profile, "cached", cached, "access_key_id", aws.ToString(creds.AccessKeyId), "expiration", aws.ToTime(creds.Expiration), "elapsed", time.Since(start))
+
+	return json.NewEncoder(os.Stdout).Encode(credentialProcessResponse(creds))
+}
 
-	return json.NewEncoder(os.Stdout).Encode(processcreds.CredentialProcessResponse{
+func credentialProcessResponse(creds *ststypes.Credentials) processcreds.CredentialProcessResponse {
+	return processcreds.CredentialProcessResponse{
 		Version:         1,
 		AccessKeyID:     aws.ToString(creds.AccessKeyId),
 		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
 		SessionToken:    aws.ToString(creds.SessionToken),
 		Expiration:      creds.Expiration,
-	})
+	}
 }
 
-const expiryWindow = 5 * time.Minute
+// backendItem returns where the --backend store keeps the access key, which
+// cached sessions are validated against.
+func (cli *CLI) backendItem() backend.Item {
+	switch cli.Backend {
+	case bwcreds.Name:
+		return backend.Item{
+			Item:                 cli.BwItem,
+			AccessKeyIDField:     cli.BwAccessKeyIDField,
+			SecretAccessKeyField: cli.BwSecretAccessKeyField,
+		}
+	case passcreds.Name:
+		return backend.Item{
+			Item:                 cli.PassEntry,
+			AccessKeyIDField:     cli.PassAccessKeyIDField,
+			SecretAccessKeyField: cli.PassSecretAccessKeyField,
+		}
+	case vaultcreds.Name:
+		return backend.Item{
+			Vault:                cli.VaultMount,
+			Item:                 cli.VaultPath,
+			AccessKeyIDField:     cli.VaultAccessKeyIDField,
+			SecretAccessKeyField: cli.VaultSecretAccessKeyField,
+		}
+	}
+	return backend.Item{
+		Vault:                cli.OpVault,
+		Item:                 cli.OpItem,
+		AccessKeyIDField:     cli.OpAccessKeyIDField,
+		SecretAccessKeyField: cli.OpSecretAccessKeyField,
+	}
+}
 
-func cacheDir() (string, error) {
-	dir := os.Getenv("XDG_CACHE_HOME")
-	if dir == "" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return "", err
+func (cli *CLI) backend() (backend.Backend, error) {
+	item := cli.backendItem()
+	switch cli.Backend {
+	case bwcreds.Name:
+		if item.Item == "" {
+			return nil, errors.New("--bw-item is required with --backend bw")
+		}
+		return bwcreds.NewCredentialSource(item.Item, func(s *bwcreds.CredentialSource) {
+			s.CLIPath = cli.BwCLIPath
+			s.Item = item
+		}), nil
+	case passcreds.Name:
+		if item.Item == "" {
+			return nil, errors.New("--pass-entry is required with --backend pass")
 		}
-		dir = filepath.Join(home, ".cache")
+		return passcreds.NewCredentialSource(item.Item, func(s *passcreds.CredentialSource) {
+			s.CLIPath = cli.PassCLIPath
+			s.Item = item
+		}), nil
+	case vaultcreds.Name:
+		if cli.VaultAddr == "" || item.Item == "" {
+			return nil, errors.New("--vault-addr (or VAULT_ADDR) and --vault-path are required with --backend vault")
+		}
+		return vaultcreds.NewCredentialSource(cli.VaultAddr, item.Vault, item.Item, func(s *vaultcreds.CredentialSource) {
+			if cli.VaultTokenFile != "" {
+				s.TokenFile = cli.VaultTokenFile
+			}
+			s.Namespace = cli.VaultNamespace
+			s.CACert = cli.VaultCaCert
+			s.Item = item
+		}), nil
+	}
+
+	if item.Vault == "" || item.Item == "" {
+		return nil, errors.New("--op-vault and --op-item are required")
+	}
+	return opcreds.NewCredentialSource(item.Vault, item.Item, func(s *opcreds.CredentialSource) {
+		s.CLIPath = cli.OpCLIPath
+		s.Item = item
+	}), nil
+}
+
+func (cli *CLI) otpSource(base backend.Backend, cacheDir, mfaSerial string) (otp.Source, error) {
+	statePath := otp.StatePath(cacheDir, mfaSerial)
+	switch cli.MfaSource {
+	case "backend":
+		otpBackend, ok := base.(backend.OTPBackend)
+		if !ok {
+			return nil, fmt.Errorf("--backend %s cannot read MFA codes; choose another --mfa-source", base.Name())
+		}
+		return otp.NewReuseGuard(otpBackend.OTPSource(), statePath), nil
+	case "op":
+		return otp.NewReuseGuard(opcreds.NewOTPSource(cli.OpVault, cli.OpItem, func(s *opcreds.OTPSource) {
+			s.CLIPath = cli.OpCLIPath
+		}), statePath), nil
+	case "op-totp":
+		return otp.NewReuseGuard(opcreds.NewTOTPSource(cli.OpVault, cli.OpItem, cli.OpTOTPSecretField, func(s *opcreds.TOTPSource) {
+			s.CLIPath = cli.OpCLIPath
+		}), statePath), nil
+	case "ykman":
+		return otp.NewReuseGuard(&otp.YkmanSource{
+			Path:    cli.YkmanPath,
+			Account: cli.YkmanAccount,
+		}, statePath), nil
+	}
+	tty := &otp.TTYSource{
+		Profile:   cli.Profile,
+		MfaSerial: mfaSerial,
+		Timeout:   cli.MfaTimeout,
+	}
+	pinentry := &otp.PinentrySource{
+		Program:   cli.PinentryProgram,
+		Profile:   cli.Profile,
+		MfaSerial: mfaSerial,
+		Timeout:   cli.MfaTimeout,
+	}
+	switch cli.MfaSource {
+	case "tty":
+		return tty, nil
+	case "pinentry":
+		return pinentry, nil
+	}
+	return &otp.AutoSource{TTY: tty, Pinentry: pinentry}, nil
+}
+
+// mfaSerial prefers --mfa-serial over mfa_serial in the profile, for users
+// with several MFA devices.
+func (cli *CLI) mfaSerial(cfg config.SharedConfig) string {
+	if cli.MfaSerial != "" {
+		return cli.MfaSerial
+	}
+	return cfg.MFASerial
+}
+
+func (cli *CLI) newCachedSessionProvider(ctx context.Context, cfg config.SharedConfig) (*sessioncache.Provider, error) {
+	if cli.ExpiryWindow >= cli.Duration {
+		return nil, fmt.Errorf("--expiry-window %s must be shorter than --duration %s", cli.ExpiryWindow, cli.Duration)
+	}
+	if token, ok := cli.webIdentityToken(cfg); ok {
+		return cli.newWebIdentitySessionProvider(ctx, cfg, token)
+	}
+	if err := cli.validateSessionTags(); err != nil {
+		return nil, err
+	}
+	if err := cli.validateSessionPolicies(); err != nil {
+		return nil, err
+	}
+	if err := cli.validateFederation(); err != nil {
+		return nil, err
+	}
+
+	base, err := cli.backend()
+	if err != nil {
+		return nil, err
+	}
+	cachedCreds := aws.NewCredentialsCache(base)
+	awsCfg, err := cli.loadAWSConfig(ctx, cachedCreds)
+	if err != nil {
+		return nil, err
+	}
+	stsClient := cli.newSTSClient(awsCfg)
+	if cli.Federation {
+		return cli.newFederationSessionProvider(stsClient, base, cachedCreds)
+	}
+	if err := cli.validateRefresh(base); err != nil {
+		return nil, err
+	}
+	iamClient := newIAMClient(awsCfg)
+	mfaSerial := cli.mfaSerial(cfg)
+
+	dir, err := sessioncache.DefaultDir()
+	if err != nil {
+		return nil, err
+	}
+	source, err := cli.otpSource(base, dir, mfaSerial)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionPolicy, err := readPolicyFile(cli.SessionPolicyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceIdentity, defaultSourceIdentity := cli.sourceIdentity(base)
+
+	var sessionProvider stssession.Provider = stssession.NewSessionTokenProvider(stsClient, cachedCreds, source, mfaSerial, func(p *stssession.SessionTokenProvider) {
+		p.MfaDeviceClient = iamClient
+		p.MfaRetries = cli.MfaRetries
+		p.Duration = cli.Duration
+	})
+	if cli.RoleArn != "" {
+		sessionProvider = stssession.NewAssumeRoleProvider(stsClient, cachedCreds, source, mfaSerial, cli.RoleArn, func(p *stssession.AssumeRoleProvider) {
+			p.IamClient = iamClient
+			p.MfaDeviceClient = iamClient
+			p.RoleSessionName = cli.RoleSessionName
+			p.MfaRetries = cli.MfaRetries
+			p.Duration = cli.Duration
+			p.Tags = cli.Tag
+			p.TransitiveTagKeys = cli.TransitiveTagKey
+			p.SourceIdentity = sourceIdentity
+			p.DefaultSourceIdentity = defaultSourceIdentity
+			p.Policy = sessionPolicy
+			p.PolicyArns = cli.SessionPolicyArn
+		})
+	}
+
+	return cli.cacheSession(sessionProvider, func(c *sessioncache.Provider) {
+		c.CacheDir = dir
+		c.Backend = base.Name()
+		c.Item = cli.backendItem()
+		c.MfaSerial = mfaSerial
+		c.RoleArn = cli.RoleArn
+		c.Tags = cli.Tag
+		c.TransitiveTagKeys = cli.TransitiveTagKey
+		c.SourceIdentity = cli.SourceIdentity
+		c.Policy = sessionPolicy
+		c.PolicyArns = cli.SessionPolicyArn
+	})
+}
+
+// cacheSession wraps sessionProvider in the on-disk cache for the profile.
+// keyFn sets the parameters the cached session is validated against.
+func (cli *CLI) cacheSession(sessionProvider stssession.Provider, keyFn func(*sessioncache.Provider)) (*sessioncache.Provider, error) {
+	provider, err := sessioncache.New(sessionProvider, cli.Profile, func(c *sessioncache.Provider) {
+		c.ExpiryWindow = cli.ExpiryWindow
+		c.RefreshWindow = cli.RefreshWindow
+		keyFn(c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	provider.BackgroundRefresh = func() error {
+		return spawnBackgroundRefresh(refreshLockPath(provider), os.Args[1:])
+	}
+	if cli.AuditLog != "" {
+		provider.Audit = cli.auditLog()
+		provider.Caller = cli.auditCaller()
+	}
+	return provider, nil
+}
+
+// auditCaller is the process the credentials are for: credential_process is
+// run by the tool asking for them, while a background refresh is detached
+// from its parent and only knows the process that started it.
+func (cli *CLI) auditCaller() audit.Caller {
+	if cli.BackgroundRefresh == "" {
+		return audit.ProcessCaller(os.Getppid())
+	}
+	caller := audit.ProcessCaller(cli.BackgroundRefreshCaller)
+	caller.Kind = audit.CallerBackgroundRefresh
+	return caller
+}
+
+func (cli *CLI) auditLog() *audit.Log {
+	return &audit.Log{
+		Path:       cli.AuditLog,
+		MaxSize:    int64(cli.AuditMaxSizeMB) << 20,
+		MaxBackups: cli.AuditMaxBackups,
 	}
-	return dir, nil
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+)
+
+type fakeStsSessionProvider struct {
+	creds  *ststypes.Credentials
+	err    error
+	called int
+}
+
+func (f *fakeStsSessionProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
+	f.called++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.creds, nil
+}
+
+func (f *fakeStsSessionProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := f.RetrieveStsCredentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+		CanExpire:       true,
+		Expires:         aws.ToTime(creds.Expiration),
+	}, nil
+}
+
+func newStsCreds(accessKey, secret, token string, expiration time.Time) *ststypes.Credentials {
+	return &ststypes.Credentials{
+		AccessKeyId:     aws.String(accessKey),
+		SecretAccessKey: aws.String(secret),
+		SessionToken:    aws.String(token),
+		Expiration:      aws.Time(expiration),
+	}
+}
+
+func defaultItem() backend.Item {
+	return backend.Item{
+		Vault:                "vault-a",
+		Item:                 "item-a",
+		AccessKeyIDField:     "username",
+		SecretAccessKeyField: "credential",
+	}
+}
@@ -0,0 +1,194 @@
+// Package audit records which credentials were handed to which process.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record describes one set of credentials returned to a caller.
+type Record struct {
+	Time              time.Time `json:"time"`
+	Profile           string    `json:"profile"`
+	Backend           string    `json:"backend,omitempty"`
+	Vault             string    `json:"vault"`
+	Item              string    `json:"item"`
+	MfaSerial         string    `json:"mfa_serial"`
+	RoleArn           string    `json:"role_arn,omitempty"`
+	Operation         string    `json:"operation"`
+	AccessKeyIDPrefix string    `json:"access_key_id_prefix"`
+	Expiration        time.Time `json:"expiration"`
+	CacheHit          bool      `json:"cache_hit"`
+	CallerKind        string    `json:"caller_kind,omitempty"`
+	CallerPID         int       `json:"caller_pid"`
+	CallerCommand     string    `json:"caller_command"`
+}
+
+// CallerBackgroundRefresh is the Kind of a Caller that renewed a session in
+// the background; its PID is the process that started the refresh.
+const CallerBackgroundRefresh = "background-refresh"
+
+// Caller is the process credentials are returned to. Who that is depends on
+// how the credentials are requested, so the caller of the library fills it in.
+// Kind is empty for a process that asked for the credentials itself.
+type Caller struct {
+	Kind    string
+	PID     int
+	Command string
+}
+
+// ProcessCaller returns the Caller for pid, looking up its command line.
+func ProcessCaller(pid int) Caller {
+	return Caller{PID: pid, Command: processCommandLine(pid)}
+}
+
+// Auditor receives a Record whenever credentials are returned.
+type Auditor interface {
+	Log(rec Record) error
+}
+
+// Log is an Auditor appending JSON lines to Path, rotating it to Path.1,
+// Path.2, ... once it would grow beyond MaxSize bytes. Only MaxBackups
+// rotated logs are kept; with none the log is not rotated at all, since
+// rotation would delete every record. Concurrent processes take turns
+// through a lock on Path.lock.
+type Log struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+}
+
+func (l *Log) Log(rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if err := os.MkdirAll(filepath.Dir(l.Path), 0700); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(l.Path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = lock.Close()
+	}()
+
+	// The lock is held from the size check to the append, so that a
+	// concurrent rotation cannot move the file in between.
+	if err := lockFile(lock); err != nil {
+		return err
+	}
+	defer func() {
+		_ = unlockFile(lock)
+	}()
+
+	if err := l.rotate(int64(len(data))); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(l.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (l *Log) backupPath(n int) string {
+	return l.Path + "." + strconv.Itoa(n)
+}
+
+func (l *Log) rotate(incoming int64) error {
+	if l.MaxSize <= 0 || l.MaxBackups <= 0 {
+		return nil
+	}
+	info, err := os.Stat(l.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size()+incoming <= l.MaxSize {
+		return nil
+	}
+
+	for n := l.MaxBackups - 1; n >= 1; n-- {
+		if err := os.Rename(l.backupPath(n), l.backupPath(n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(l.Path, l.backupPath(1))
+}
+
+// Records reads the rotated backups from oldest to newest followed by the
+// current log, skipping lines that are not valid records.
+func (l *Log) Records() ([]Record, error) {
+	paths := []string{}
+	for n := l.MaxBackups; n >= 1; n-- {
+		paths = append(paths, l.backupPath(n))
+	}
+	paths = append(paths, l.Path)
+
+	var records []Record
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var rec Record
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				continue
+			}
+			records = append(records, rec)
+		}
+		err = scanner.Err()
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+func processCommandLine(pid int) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err == nil {
+		return strings.TrimSpace(string(bytes.ReplaceAll(data, []byte{0}, []byte{' '})))
+	}
+
+	out, err := exec.Command("ps", "-o", "command=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// AccessKeyIDPrefix shortens an access key ID to what is needed to tell keys
+// apart in logs.
+func AccessKeyIDPrefix(id string) string {
+	if len(id) <= 8 {
+		return id
+	}
+	return id[:8] + "..."
+}
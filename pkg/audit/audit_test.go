@@ -0,0 +1,95 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestLog_Rotation(t *testing.T) {
+	log := &Log{
+		Path:       filepath.Join(t.TempDir(), "audit.log"),
+		MaxSize:    300,
+		MaxBackups: 2,
+	}
+
+	for i := range 10 {
+		if err := log.Log(Record{Profile: "p", CallerPID: i}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for _, path := range []string{log.Path, log.backupPath(1), log.backupPath(2)} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("expected %s to exist: %v", path, err)
+		}
+		if info.Size() > log.MaxSize {
+			t.Errorf("%s size = %d, want <= %d", path, info.Size(), log.MaxSize)
+		}
+	}
+	if _, err := os.Stat(log.backupPath(3)); !os.IsNotExist(err) {
+		t.Errorf("expected %s not to exist, err=%v", log.backupPath(3), err)
+	}
+
+	records, err := log.Records()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) == 0 || records[len(records)-1].CallerPID != 9 {
+		t.Errorf("last record = %+v, want CallerPID 9", records)
+	}
+	for i := 1; i < len(records); i++ {
+		if records[i].CallerPID < records[i-1].CallerPID {
+			t.Errorf("records are not in order: %+v", records)
+		}
+	}
+}
+
+func TestLog_ConcurrentRotation(t *testing.T) {
+	log := &Log{
+		Path:       filepath.Join(t.TempDir(), "audit.log"),
+		MaxSize:    200,
+		MaxBackups: 100,
+	}
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Go(func() {
+			if err := log.Log(Record{Profile: "p", CallerPID: i}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+
+	records, err := log.Records()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 50 {
+		t.Errorf("len(records) = %d, want 50", len(records))
+	}
+}
+
+func TestLog_NoBackupsKeepsRecords(t *testing.T) {
+	log := &Log{
+		Path:    filepath.Join(t.TempDir(), "audit.log"),
+		MaxSize: 100,
+	}
+
+	for i := range 10 {
+		if err := log.Log(Record{Profile: "p", CallerPID: i}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	records, err := log.Records()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 10 {
+		t.Errorf("len(records) = %d, want all 10", len(records))
+	}
+}
@@ -0,0 +1,86 @@
+// Package backend defines what a secret store provides to be used as the
+// source of the long-term access key, and optionally of MFA codes.
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/otp"
+)
+
+const (
+	DefaultAccessKeyIDField     = "Access key ID"
+	DefaultSecretAccessKeyField = "Secret access key"
+
+	// DefaultName is the backend of anything that names none, since
+	// 1Password was the only store before the others were added.
+	DefaultName = "op"
+)
+
+// Backend reads the long-term access key pair of an IAM user from a secret
+// store.
+type Backend interface {
+	aws.CredentialsProvider
+	// Name identifies the store, such as "op" or "bw".
+	Name() string
+}
+
+// OTPBackend is implemented by backends that can also read the current MFA
+// code from the item holding the access key.
+type OTPBackend interface {
+	Backend
+	OTPSource() otp.TimeStepSource
+}
+
+// UserBackend is implemented by backends that know who is signed in to the
+// store, which identifies the person behind a session.
+type UserBackend interface {
+	Backend
+	User(ctx context.Context) (string, error)
+}
+
+// Item locates the access key in a secret store: the vault, the item, and
+// the names of the fields holding each half of the key pair. Stores without
+// vaults leave Vault empty.
+type Item struct {
+	Vault                string
+	Item                 string
+	AccessKeyIDField     string
+	SecretAccessKeyField string
+}
+
+// Error reports a failed secret store command, including what it printed on
+// stderr.
+type Error struct {
+	Backend string
+	Command string
+	Stderr  string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("%s %s: %v", e.Backend, e.Command, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %v\n%s", e.Backend, e.Command, e.Err, e.Stderr)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError returns an Error for a failed command, keeping its stderr when
+// err is an *exec.ExitError.
+func NewError(backend, command string, err error) *Error {
+	backendErr := &Error{Backend: backend, Command: command, Err: err}
+	if exitErr, ok := errors.AsType[*exec.ExitError](err); ok {
+		backendErr.Stderr = strings.TrimSpace(string(exitErr.Stderr))
+	}
+	return backendErr
+}
@@ -0,0 +1,156 @@
+// Package bwcreds reads AWS access keys and MFA codes from Bitwarden through
+// the bw CLI.
+package bwcreds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+	"github.com/scizorman/op-aws-credential-process/pkg/otp"
+)
+
+const (
+	Name           = "bw"
+	DefaultCLIPath = "bw"
+)
+
+// CredentialSource is a backend.Backend returning the long-term access key
+// stored in a Bitwarden item. The fields are looked up among the item's
+// custom fields; "username" and "password" also match the login, so the key
+// pair can be kept in a plain login item. Vault is not used, since bw looks
+// items up by ID or name across the whole vault.
+type CredentialSource struct {
+	CLIPath string
+	backend.Item
+}
+
+// NewCredentialSource returns a source for item, which is an ID or a name,
+// using the default bw path and field names unless optFns change them.
+func NewCredentialSource(item string, optFns ...func(*CredentialSource)) *CredentialSource {
+	s := &CredentialSource{
+		CLIPath: DefaultCLIPath,
+		Item: backend.Item{
+			Item:                 item,
+			AccessKeyIDField:     backend.DefaultAccessKeyIDField,
+			SecretAccessKeyField: backend.DefaultSecretAccessKeyField,
+		},
+	}
+	for _, fn := range optFns {
+		fn(s)
+	}
+	return s
+}
+
+type bwItem struct {
+	Login struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"login"`
+	Fields []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"fields"`
+}
+
+func (i bwItem) field(name string) string {
+	for _, f := range i.Fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	switch name {
+	case "username":
+		return i.Login.Username
+	case "password":
+		return i.Login.Password
+	}
+	return ""
+}
+
+func (s *CredentialSource) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	out, err := run(ctx, s.CLIPath, "get item", s.Item.Item)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	var item bwItem
+	if err := json.Unmarshal(out, &item); err != nil {
+		return aws.Credentials{}, &backend.Error{Backend: Name, Command: "get item", Err: err}
+	}
+
+	creds := aws.Credentials{
+		AccessKeyID:     item.field(s.AccessKeyIDField),
+		SecretAccessKey: item.field(s.SecretAccessKeyField),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return aws.Credentials{}, &backend.Error{Backend: Name, Command: "get item", Err: errors.New("missing credentials in bw output")}
+	}
+	return creds, nil
+}
+
+func (s *CredentialSource) Name() string {
+	return Name
+}
+
+// OTPSource returns a source reading the code of the item's TOTP with bw get
+// totp.
+func (s *CredentialSource) OTPSource() otp.TimeStepSource {
+	return NewOTPSource(s.Item.Item, func(o *OTPSource) {
+		o.CLIPath = s.CLIPath
+	})
+}
+
+// run runs bw get <object> <item> without prompting for the master password,
+// so a locked vault fails instead of hanging on a prompt nobody sees.
+func run(ctx context.Context, cliPath, command, item string) ([]byte, error) {
+	args := append(strings.Fields(command), item, "--nointeraction")
+	cmd := exec.CommandContext(ctx, cliPath, args...)
+	slog.DebugContext(ctx, "running bw", "command", command, "item", item)
+	start := time.Now()
+	out, err := cmd.Output()
+	if err != nil {
+		slog.DebugContext(ctx, "bw failed", "command", command, "elapsed", time.Since(start), "error", err)
+		return nil, backend.NewError(Name, command, err)
+	}
+	slog.InfoContext(ctx, "bw succeeded", "command", command, "item", item, "elapsed", time.Since(start))
+	return out, nil
+}
+
+// OTPSource reads the current code of an item's TOTP with bw get totp.
+type OTPSource struct {
+	CLIPath string
+	Item    string
+}
+
+func NewOTPSource(item string, optFns ...func(*OTPSource)) *OTPSource {
+	s := &OTPSource{CLIPath: DefaultCLIPath, Item: item}
+	for _, fn := range optFns {
+		fn(s)
+	}
+	return s
+}
+
+func (s *OTPSource) OTP(ctx context.Context) (string, error) {
+	out, err := run(ctx, s.CLIPath, "get totp", s.Item)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.TrimSpace(string(out))
+	if code == "" {
+		return "", &backend.Error{Backend: Name, Command: "get totp", Err: errors.New("missing otp in bw output")}
+	}
+	return code, nil
+}
+
+func (s *OTPSource) TimeStep() time.Duration {
+	return otp.DefaultTimeStep
+}
@@ -0,0 +1,88 @@
+package bwcreds
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/scizorman/op-aws-credential-process/internal/testutil"
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+)
+
+var _ backend.OTPBackend = (*CredentialSource)(nil)
+
+func TestCredentialSource(t *testing.T) {
+	program := testutil.WriteCommand(t, "bw", `if [ "$*" != "get item aws --nointeraction" ]; then
+  echo "unexpected args: $*" >&2
+  exit 1
+fi
+echo '{"object":"item","name":"aws","login":{"username":"someone","password":"hunter2"},"fields":[{"name":"Access key ID","value":"AKIAEXAMPLE","type":0},{"name":"Secret access key","value":"secret","type":1}]}'
+`)
+	source := NewCredentialSource("aws", func(s *CredentialSource) {
+		s.CLIPath = program
+	})
+
+	creds, err := source.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if creds.AccessKeyID != "AKIAEXAMPLE" || creds.SecretAccessKey != "secret" {
+		t.Errorf("creds = %+v, want AKIAEXAMPLE/secret", creds)
+	}
+}
+
+func TestCredentialSource_Login(t *testing.T) {
+	program := testutil.WriteCommand(t, "bw", `echo '{"object":"item","name":"aws","login":{"username":"AKIAEXAMPLE","password":"secret"}}'
+`)
+	source := NewCredentialSource("aws", func(s *CredentialSource) {
+		s.CLIPath = program
+		s.AccessKeyIDField = "username"
+		s.SecretAccessKeyField = "password"
+	})
+
+	creds, err := source.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if creds.AccessKeyID != "AKIAEXAMPLE" || creds.SecretAccessKey != "secret" {
+		t.Errorf("creds = %+v, want AKIAEXAMPLE/secret", creds)
+	}
+}
+
+func TestCredentialSource_Error(t *testing.T) {
+	program := testutil.WriteCommand(t, "bw", `echo "Vault is locked." >&2
+exit 1
+`)
+	source := NewCredentialSource("aws", func(s *CredentialSource) {
+		s.CLIPath = program
+	})
+
+	_, err := source.Retrieve(context.Background())
+	bwErr, ok := errors.AsType[*backend.Error](err)
+	if !ok {
+		t.Fatalf("err = %v, want *backend.Error", err)
+	}
+	if bwErr.Backend != "bw" || bwErr.Stderr != "Vault is locked." {
+		t.Errorf("err = %+v, want bw error with stderr %q", bwErr, "Vault is locked.")
+	}
+}
+
+func TestOTPSource(t *testing.T) {
+	program := testutil.WriteCommand(t, "bw", `if [ "$*" != "get totp aws --nointeraction" ]; then
+  echo "unexpected args: $*" >&2
+  exit 1
+fi
+echo 123456
+`)
+	source := NewCredentialSource("aws", func(s *CredentialSource) {
+		s.CLIPath = program
+	}).OTPSource()
+
+	code, err := source.OTP(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code != "123456" {
+		t.Errorf("code = %q, want %q", code, "123456")
+	}
+}
@@ -0,0 +1,240 @@
+// Package opcreds reads AWS access keys and MFA codes from 1Password through
+// the op CLI.
+package opcreds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+	"github.com/scizorman/op-aws-credential-process/pkg/otp"
+)
+
+const (
+	Name           = "op"
+	DefaultCLIPath = "op"
+)
+
+// CredentialSource is a backend.Backend returning the long-term access key
+// stored in a 1Password item.
+type CredentialSource struct {
+	CLIPath string
+	backend.Item
+}
+
+// NewCredentialSource returns a source for the item in vault, using the
+// default op path and field labels unless optFns change them.
+func NewCredentialSource(vault, item string, optFns ...func(*CredentialSource)) *CredentialSource {
+	s := &CredentialSource{
+		CLIPath: DefaultCLIPath,
+		Item: backend.Item{
+			Vault:                vault,
+			Item:                 item,
+			AccessKeyIDField:     backend.DefaultAccessKeyIDField,
+			SecretAccessKeyField: backend.DefaultSecretAccessKeyField,
+		},
+	}
+	for _, fn := range optFns {
+		fn(s)
+	}
+	return s
+}
+
+func (s *CredentialSource) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	fields, err := itemFields(ctx, s.CLIPath, s.Vault, s.Item.Item, s.AccessKeyIDField, s.SecretAccessKeyField)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	creds := aws.Credentials{
+		AccessKeyID:     fields[s.AccessKeyIDField],
+		SecretAccessKey: fields[s.SecretAccessKeyField],
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return aws.Credentials{}, &backend.Error{Backend: Name, Command: "item get", Err: errors.New("missing credentials in op output")}
+	}
+	return creds, nil
+}
+
+func (s *CredentialSource) Name() string {
+	return Name
+}
+
+// OTPSource returns a source reading the one-time password field of the
+// same item.
+func (s *CredentialSource) OTPSource() otp.TimeStepSource {
+	return NewOTPSource(s.Vault, s.Item.Item, func(o *OTPSource) {
+		o.CLIPath = s.CLIPath
+	})
+}
+
+// User returns the email address of the account op is signed in to.
+func (s *CredentialSource) User(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, s.CLIPath, "whoami", "--format", "json").Output()
+	if err != nil {
+		return "", backend.NewError(Name, "whoami", err)
+	}
+
+	var account struct {
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(out, &account); err != nil {
+		return "", &backend.Error{Backend: Name, Command: "whoami", Err: err}
+	}
+	if account.Email == "" {
+		return "", &backend.Error{Backend: Name, Command: "whoami", Err: errors.New("missing email in op output")}
+	}
+	return account.Email, nil
+}
+
+type field struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// itemFields reads the labelled fields of an item. op prints a single
+// object instead of an array when only one field is requested.
+func itemFields(ctx context.Context, cliPath, vault, item string, labels ...string) (map[string]string, error) {
+	selectors := make([]string, len(labels))
+	for i, label := range labels {
+		selectors[i] = "label=" + label
+	}
+	cmd := exec.CommandContext(ctx, cliPath,
+		"item", "get", item,
+		"--vault", vault,
+		"--fields", strings.Join(selectors, ","),
+		"--format", "json",
+	)
+	slog.DebugContext(ctx, "running op item get", "vault", vault, "item", item)
+	start := time.Now()
+	out, err := cmd.Output()
+	if err != nil {
+		slog.DebugContext(ctx, "op item get failed", "elapsed", time.Since(start), "error", err)
+		return nil, backend.NewError(Name, "item get", err)
+	}
+	slog.InfoContext(ctx, "op item get succeeded", "vault", vault, "item", item, "elapsed", time.Since(start))
+
+	var items []field
+	if err := json.Unmarshal(out, &items); err != nil {
+		var single field
+		if err := json.Unmarshal(out, &single); err != nil {
+			return nil, &backend.Error{Backend: Name, Command: "item get", Err: err}
+		}
+		items = []field{single}
+	}
+
+	fields := make(map[string]string, len(items))
+	for _, item := range items {
+		fields[item.Label] = item.Value
+	}
+	return fields, nil
+}
+
+// OTPSource reads the code from the one-time password field of an item
+// with op item get --otp.
+type OTPSource struct {
+	CLIPath string
+	Vault   string
+	Item    string
+}
+
+func NewOTPSource(vault, item string, optFns ...func(*OTPSource)) *OTPSource {
+	s := &OTPSource{CLIPath: DefaultCLIPath, Vault: vault, Item: item}
+	for _, fn := range optFns {
+		fn(s)
+	}
+	return s
+}
+
+func (s *OTPSource) OTP(ctx context.Context) (string, error) {
+	cmd := exec.CommandContext(ctx, s.CLIPath,
+		"item", "get", s.Item,
+		"--vault", s.Vault,
+		"--otp",
+	)
+	slog.DebugContext(ctx, "running op item get --otp", "vault", s.Vault, "item", s.Item)
+	start := time.Now()
+	out, err := cmd.Output()
+	if err != nil {
+		slog.DebugContext(ctx, "op item get --otp failed", "elapsed", time.Since(start), "error", err)
+		return "", backend.NewError(Name, "item get --otp", err)
+	}
+	slog.InfoContext(ctx, "op item get --otp succeeded", "vault", s.Vault, "item", s.Item, "elapsed", time.Since(start))
+
+	code := strings.TrimSpace(string(out))
+	if code == "" {
+		return "", &backend.Error{Backend: Name, Command: "item get --otp", Err: errors.New("missing otp in op output")}
+	}
+	return code, nil
+}
+
+func (s *OTPSource) TimeStep() time.Duration {
+	return otp.DefaultTimeStep
+}
+
+// TOTPSource computes the code locally from a TOTP secret kept in a plain
+// 1Password field, for items without a one-time password field.
+type TOTPSource struct {
+	CLIPath string
+	Vault   string
+	Item    string
+	Field   string
+	Now     func() time.Time
+
+	mu     sync.Mutex
+	period time.Duration
+}
+
+func NewTOTPSource(vault, item, field string, optFns ...func(*TOTPSource)) *TOTPSource {
+	s := &TOTPSource{CLIPath: DefaultCLIPath, Vault: vault, Item: item, Field: field}
+	for _, fn := range optFns {
+		fn(s)
+	}
+	return s
+}
+
+func (s *TOTPSource) OTP(ctx context.Context) (string, error) {
+	fields, err := itemFields(ctx, s.CLIPath, s.Vault, s.Item, s.Field)
+	if err != nil {
+		return "", err
+	}
+	seed := fields[s.Field]
+	if seed == "" {
+		return "", &backend.Error{Backend: Name, Command: "item get", Err: fmt.Errorf("missing field %q in op output", s.Field)}
+	}
+
+	key, err := otp.ParseTOTPKey(seed)
+	if err != nil {
+		return "", fmt.Errorf("field %q: %w", s.Field, err)
+	}
+
+	s.mu.Lock()
+	s.period = key.Period
+	s.mu.Unlock()
+
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+	return key.Code(now)
+}
+
+// TimeStep reports the period of the last secret read, which is the default
+// until the first code has been computed.
+func (s *TOTPSource) TimeStep() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.period == 0 {
+		return otp.DefaultTimeStep
+	}
+	return s.period
+}
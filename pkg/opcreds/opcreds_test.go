@@ -0,0 +1,121 @@
+package opcreds
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/scizorman/op-aws-credential-process/internal/testutil"
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+	"github.com/scizorman/op-aws-credential-process/pkg/otp"
+)
+
+var (
+	_ backend.OTPBackend  = (*CredentialSource)(nil)
+	_ backend.UserBackend = (*CredentialSource)(nil)
+)
+
+func TestCredentialSource(t *testing.T) {
+	program := testutil.WriteCommand(t, "op", `if [ "$*" != "item get aws --vault Private --fields label=Access key ID,label=Secret access key --format json" ]; then
+  echo "unexpected args: $*" >&2
+  exit 1
+fi
+echo '[{"label":"Access key ID","value":"AKIAEXAMPLE"},{"label":"Secret access key","value":"secret"}]'
+`)
+	source := NewCredentialSource("Private", "aws", func(s *CredentialSource) {
+		s.CLIPath = program
+	})
+
+	creds, err := source.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if creds.AccessKeyID != "AKIAEXAMPLE" || creds.SecretAccessKey != "secret" {
+		t.Errorf("creds = %+v, want AKIAEXAMPLE/secret", creds)
+	}
+}
+
+func TestCredentialSource_Error(t *testing.T) {
+	program := testutil.WriteCommand(t, "op", `echo "[ERROR] item not found" >&2
+exit 1
+`)
+	source := NewCredentialSource("Private", "aws", func(s *CredentialSource) {
+		s.CLIPath = program
+	})
+
+	_, err := source.Retrieve(context.Background())
+	opErr, ok := errors.AsType[*backend.Error](err)
+	if !ok {
+		t.Fatalf("err = %v, want *backend.Error", err)
+	}
+	if opErr.Stderr != "[ERROR] item not found" {
+		t.Errorf("Stderr = %q, want %q", opErr.Stderr, "[ERROR] item not found")
+	}
+}
+
+func TestCredentialSource_User(t *testing.T) {
+	program := testutil.WriteCommand(t, "op", `if [ "$*" != "whoami --format json" ]; then
+  echo "unexpected args: $*" >&2
+  exit 1
+fi
+echo '{"url":"my.1password.com","email":"alice@example.com","user_uuid":"ABC","account_uuid":"DEF","user_type":"HUMAN"}'
+`)
+	source := NewCredentialSource("Private", "aws", func(s *CredentialSource) {
+		s.CLIPath = program
+	})
+
+	user, err := source.User(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user != "alice@example.com" {
+		t.Errorf("User = %q, want %q", user, "alice@example.com")
+	}
+}
+
+func TestTOTPSource(t *testing.T) {
+	program := testutil.WriteCommand(t, "op", `if [ "$*" != "item get aws --vault Private --fields label=TOTP secret --format json" ]; then
+  echo "unexpected args: $*" >&2
+  exit 1
+fi
+echo '{"label":"TOTP secret","value":"otpauth://totp/AWS?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=8&period=60"}'
+`)
+	source := &TOTPSource{
+		CLIPath: program,
+		Vault:   "Private",
+		Item:    "aws",
+		Field:   "TOTP secret",
+		Now: func() time.Time {
+			return time.Unix(1111111111, 0)
+		},
+	}
+
+	if got := source.TimeStep(); got != otp.DefaultTimeStep {
+		t.Errorf("TimeStep before OTP = %v, want %v", got, otp.DefaultTimeStep)
+	}
+	code, err := source.OTP(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want, err := (otp.TOTPKey{Secret: []byte("12345678901234567890"), Algorithm: "SHA1", Digits: 8, Period: time.Minute}).Code(time.Unix(1111111111, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != want {
+		t.Errorf("code = %q, want %q", code, want)
+	}
+	if got := source.TimeStep(); got != time.Minute {
+		t.Errorf("TimeStep = %v, want %v", got, time.Minute)
+	}
+}
+
+func TestTOTPSource_MissingField(t *testing.T) {
+	program := testutil.WriteCommand(t, "op", `echo '{"label":"TOTP secret"}'
+`)
+	source := &TOTPSource{CLIPath: program, Vault: "Private", Item: "aws", Field: "TOTP secret"}
+
+	if _, err := source.OTP(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
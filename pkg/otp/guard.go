@@ -0,0 +1,144 @@
+package otp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StateError reports a failure to read or update the state file of a
+// ReuseGuard.
+type StateError struct {
+	Path string
+	Err  error
+}
+
+func (e *StateError) Error() string {
+	return fmt.Sprintf("otp state %s: %v", e.Path, e.Err)
+}
+
+func (e *StateError) Unwrap() error {
+	return e.Err
+}
+
+type state struct {
+	LastStep int64 `json:"last_step"`
+}
+
+// ReuseGuard records the last time step used for an MFA device and makes
+// other processes wait for the next step, since STS rejects a code that has
+// already been used.
+type ReuseGuard struct {
+	Source    TimeStepSource
+	StatePath string
+	Now       func() time.Time
+	Sleep     func(ctx context.Context, d time.Duration) error
+}
+
+// NewReuseGuard returns a guard for source that keeps its state in
+// statePath, usually StatePath(cacheDir, mfaSerial).
+func NewReuseGuard(source TimeStepSource, statePath string, optFns ...func(*ReuseGuard)) *ReuseGuard {
+	g := &ReuseGuard{Source: source, StatePath: statePath}
+	for _, fn := range optFns {
+		fn(g)
+	}
+	return g
+}
+
+// StatePath returns the state file for an MFA device under cacheDir.
+func StatePath(cacheDir, mfaSerial string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, mfaSerial)
+	return filepath.Join(cacheDir, "op-aws-credential-process", "otp", name+".json")
+}
+
+func (g *ReuseGuard) now() time.Time {
+	if g.Now == nil {
+		return time.Now()
+	}
+	return g.Now()
+}
+
+func (g *ReuseGuard) sleep(ctx context.Context, d time.Duration) error {
+	if g.Sleep != nil {
+		return g.Sleep(ctx, d)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (g *ReuseGuard) TimeStep() time.Duration {
+	return g.Source.TimeStep()
+}
+
+func (g *ReuseGuard) OTP(ctx context.Context) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(g.StatePath), 0700); err != nil {
+		return "", &StateError{Path: g.StatePath, Err: err}
+	}
+	f, err := os.OpenFile(g.StatePath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return "", &StateError{Path: g.StatePath, Err: err}
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	// The lock is held until the new step is recorded, so concurrent
+	// invocations queue up instead of fetching the same code.
+	if err := lockFile(f); err != nil {
+		return "", &StateError{Path: g.StatePath, Err: err}
+	}
+	defer func() {
+		_ = unlockFile(f)
+	}()
+
+	var st state
+	_ = json.NewDecoder(f).Decode(&st)
+
+	step := int64(g.Source.TimeStep())
+	if g.now().UnixNano()/step <= st.LastStep {
+		wait := time.Unix(0, (st.LastStep+1)*step).Sub(g.now())
+		slog.InfoContext(ctx, "waiting for the next MFA time step to avoid reusing a code", "state", g.StatePath, "wait", wait)
+		if err := g.sleep(ctx, wait); err != nil {
+			return "", err
+		}
+	}
+
+	code, err := g.Source.OTP(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	st.LastStep = g.now().UnixNano() / step
+	if err := writeState(f, st); err != nil {
+		slog.WarnContext(ctx, "failed to record MFA time step", "state", g.StatePath, "error", err)
+	}
+	return code, nil
+}
+
+func writeState(f *os.File, st state) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return json.NewEncoder(f).Encode(st)
+}
@@ -0,0 +1,70 @@
+// Package otp provides sources of MFA token codes: interactive prompts on the
+// terminal or through pinentry, YubiKey OATH accounts via ykman, and a local
+// RFC 6238 generator.
+package otp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Source returns the current MFA token code.
+type Source interface {
+	OTP(ctx context.Context) (string, error)
+}
+
+// TimeStepSource is implemented by sources that derive the code from the
+// clock, where asking again within the same time step yields the same code.
+type TimeStepSource interface {
+	Source
+	TimeStep() time.Duration
+}
+
+// DefaultTimeStep is the TOTP period used by AWS virtual MFA devices.
+const DefaultTimeStep = 30 * time.Second
+
+// ErrCancelled is returned when the user dismisses or does not answer an MFA
+// prompt.
+var ErrCancelled = errors.New("MFA prompt was cancelled")
+
+// WaitNextTimeStep blocks until the time step after the one containing now
+// begins, or ctx is done.
+func WaitNextTimeStep(ctx context.Context, step time.Duration, now time.Time) error {
+	next := time.Unix(0, (now.UnixNano()/int64(step)+1)*int64(step))
+	timer := time.NewTimer(next.Sub(now))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Normalize strips the spaces authenticator apps show between digit groups
+// and reports whether the rest is a six-digit code.
+func Normalize(input string) (string, bool) {
+	code := strings.Join(strings.Fields(input), "")
+	if len(code) != 6 {
+		return "", false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return "", false
+		}
+	}
+	return code, true
+}
+
+// promptCancelled reports an MFA prompt that was abandoned because ctx is
+// done, treating the timeout expiring as the user not answering.
+func promptCancelled(ctx context.Context, timeout time.Duration) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && timeout > 0 {
+		return fmt.Errorf("%w: no code entered within %s", ErrCancelled, timeout)
+	}
+	return ctx.Err()
+}
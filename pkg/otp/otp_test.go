@@ -0,0 +1,270 @@
+package otp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeSource struct {
+	otp    string
+	err    error
+	called int
+}
+
+func (f *fakeSource) OTP(ctx context.Context) (string, error) {
+	f.called++
+	return f.otp, f.err
+}
+
+type fakeTimeStepSource struct {
+	fakeSource
+	step time.Duration
+}
+
+func (f *fakeTimeStepSource) TimeStep() time.Duration {
+	return f.step
+}
+
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+	return nil
+}
+
+func TestReuseGuard_WaitsForNextTimeStep(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_010, 0)}
+	statePath := StatePath(t.TempDir(), "arn:aws:iam::123456789012:mfa/user")
+	newGuard := func(source *fakeTimeStepSource) *ReuseGuard {
+		return &ReuseGuard{Source: source, StatePath: statePath, Now: clock.Now, Sleep: clock.Sleep}
+	}
+
+	first := &fakeTimeStepSource{fakeSource: fakeSource{otp: "111111"}, step: 30 * time.Second}
+	if _, err := newGuard(first).OTP(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(clock.sleeps) != 0 {
+		t.Fatalf("sleeps = %v, want none", clock.sleeps)
+	}
+
+	// A second process within the same 30-second step has to wait for the
+	// start of the next one.
+	clock.now = clock.now.Add(5 * time.Second)
+	second := &fakeTimeStepSource{fakeSource: fakeSource{otp: "222222"}, step: 30 * time.Second}
+	code, err := newGuard(second).OTP(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code != "222222" {
+		t.Errorf("code = %q, want %q", code, "222222")
+	}
+	if len(clock.sleeps) != 1 || clock.sleeps[0] != 25*time.Second {
+		t.Errorf("sleeps = %v, want [25s]", clock.sleeps)
+	}
+	if second.called != 1 {
+		t.Errorf("second.called = %d, want 1", second.called)
+	}
+
+	// Once the step has passed, no waiting is needed.
+	clock.now = clock.now.Add(30 * time.Second)
+	third := &fakeTimeStepSource{fakeSource: fakeSource{otp: "333333"}, step: 30 * time.Second}
+	if _, err := newGuard(third).OTP(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(clock.sleeps) != 1 {
+		t.Errorf("sleeps = %v, want [25s]", clock.sleeps)
+	}
+}
+
+func TestReuseGuard_PerMfaSerial(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_010, 0)}
+	dir := t.TempDir()
+
+	for _, serial := range []string{"arn:aws:iam::123456789012:mfa/a", "arn:aws:iam::123456789012:mfa/b"} {
+		guard := &ReuseGuard{
+			Source:    &fakeTimeStepSource{fakeSource: fakeSource{otp: "123456"}, step: 30 * time.Second},
+			StatePath: StatePath(dir, serial),
+			Now:       clock.Now,
+			Sleep:     clock.Sleep,
+		}
+		if _, err := guard.OTP(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(clock.sleeps) != 0 {
+		t.Errorf("sleeps = %v, want none", clock.sleeps)
+	}
+}
+
+func TestStatePath(t *testing.T) {
+	got := StatePath("/tmp/cache", "arn:aws:iam::123456789012:mfa/user")
+	want := filepath.Join("/tmp/cache", "op-aws-credential-process", "otp", "arn_aws_iam__123456789012_mfa_user.json")
+	if got != want {
+		t.Errorf("StatePath = %q, want %q", got, want)
+	}
+}
+
+// fakeTerminal blocks ReadPassword on block, if set, until Close.
+type fakeTerminal struct {
+	mu      sync.Mutex
+	out     bytes.Buffer
+	inputs  []string
+	block   chan struct{}
+	closed  bool
+	reading atomic.Int32
+}
+
+func (t *fakeTerminal) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.out.Write(p)
+}
+
+func (t *fakeTerminal) ReadPassword() (string, error) {
+	t.reading.Add(1)
+	defer t.reading.Add(-1)
+	if t.block != nil {
+		<-t.block
+		return "", os.ErrClosed
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.inputs) == 0 {
+		return "", io.EOF
+	}
+	line := t.inputs[0]
+	t.inputs = t.inputs[1:]
+	return line, nil
+}
+
+func (t *fakeTerminal) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed && t.block != nil {
+		close(t.block)
+	}
+	t.closed = true
+	return nil
+}
+
+func (t *fakeTerminal) output() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.out.String()
+}
+
+func newTTYSource(tty *fakeTerminal) *TTYSource {
+	return &TTYSource{
+		Profile:   "test-profile",
+		MfaSerial: "arn:aws:iam::123456789012:mfa/user",
+		Open: func() (Terminal, error) {
+			return tty, nil
+		},
+	}
+}
+
+func TestTTYSource_StripsSpaces(t *testing.T) {
+	tty := &fakeTerminal{inputs: []string{" 123 456 "}}
+
+	code, err := newTTYSource(tty).OTP(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code != "123456" {
+		t.Errorf("code = %q, want %q", code, "123456")
+	}
+	if !strings.Contains(tty.output(), "Enter MFA code for test-profile (arn:aws:iam::123456789012:mfa/user): ") {
+		t.Errorf("prompt = %q, want profile and MFA serial", tty.output())
+	}
+	if !tty.closed {
+		t.Error("terminal was not closed")
+	}
+}
+
+func TestTTYSource_RepromptsOnMalformedInput(t *testing.T) {
+	tty := &fakeTerminal{inputs: []string{"12345", "abcdef", "1234567", "654321"}}
+
+	code, err := newTTYSource(tty).OTP(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code != "654321" {
+		t.Errorf("code = %q, want %q", code, "654321")
+	}
+	if got := strings.Count(tty.output(), "Enter MFA code"); got != 4 {
+		t.Errorf("prompted %d times, want 4", got)
+	}
+	if got := strings.Count(tty.output(), "MFA code must be 6 digits."); got != 3 {
+		t.Errorf("rejected %d times, want 3", got)
+	}
+}
+
+func TestTTYSource_EOF(t *testing.T) {
+	tty := &fakeTerminal{}
+
+	_, err := newTTYSource(tty).OTP(context.Background())
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("err = %v, want ErrCancelled", err)
+	}
+}
+
+func TestTTYSource_ContextCancelled(t *testing.T) {
+	tty := &fakeTerminal{block: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := newTTYSource(tty).OTP(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestTTYSource_Timeout(t *testing.T) {
+	tty := &fakeTerminal{block: make(chan struct{})}
+
+	source := newTTYSource(tty)
+	source.Timeout = 10 * time.Millisecond
+
+	_, err := source.OTP(context.Background())
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("err = %v, want ErrCancelled", err)
+	}
+	if got := tty.reading.Load(); got != 0 {
+		t.Errorf("%d reads still pending after the timeout", got)
+	}
+	if !tty.closed {
+		t.Error("terminal was not closed")
+	}
+}
+
+func TestTTYSource_OpenError(t *testing.T) {
+	openErr := errors.New("open /dev/tty: no such device or address")
+	source := &TTYSource{
+		Open: func() (Terminal, error) {
+			return nil, openErr
+		},
+	}
+
+	_, err := source.OTP(context.Background())
+	if !errors.Is(err, openErr) {
+		t.Errorf("err = %v, want %v", err, openErr)
+	}
+}
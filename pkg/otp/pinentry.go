@@ -0,0 +1,169 @@
+package otp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Assuan error codes carry the error source in the upper bits; only the
+// code in the lower 16 bits matters here.
+const (
+	gpgErrTimeout  = 62
+	gpgErrCanceled = 99
+)
+
+// PinentrySource asks for the MFA code through a pinentry program, for
+// callers such as IDEs that run without a controlling terminal.
+type PinentrySource struct {
+	// Program is the pinentry executable; "pinentry" when empty.
+	Program   string
+	Profile   string
+	MfaSerial string
+	Timeout   time.Duration
+}
+
+func (s *PinentrySource) OTP(ctx context.Context) (string, error) {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	program := s.Program
+	if program == "" {
+		program = "pinentry"
+	}
+	cmd := exec.CommandContext(ctx, program)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("pinentry: %w", err)
+	}
+	conn := &assuanConn{w: stdin, r: bufio.NewReader(stdout)}
+	defer func() {
+		_, _ = conn.command("BYE")
+		_ = stdin.Close()
+		_ = cmd.Wait()
+	}()
+
+	code, err := s.prompt(conn)
+	if err != nil && ctx.Err() != nil {
+		return "", promptCancelled(ctx, s.Timeout)
+	}
+	return code, err
+}
+
+func (s *PinentrySource) prompt(conn *assuanConn) (string, error) {
+	if _, err := conn.readResponse(); err != nil {
+		return "", err
+	}
+
+	setup := []string{
+		"SETTITLE op-aws-credential-process",
+		"SETDESC " + assuanEscape(fmt.Sprintf("Enter MFA code for %s (%s)", s.Profile, s.MfaSerial)),
+		"SETPROMPT MFA code:",
+	}
+	if s.Timeout > 0 {
+		setup = append(setup, "SETTIMEOUT "+strconv.Itoa(int(s.Timeout.Seconds())))
+	}
+	for _, line := range setup {
+		if _, err := conn.command(line); err != nil {
+			return "", err
+		}
+	}
+
+	for {
+		pin, err := conn.command("GETPIN")
+		if err != nil {
+			return "", err
+		}
+		if code, ok := Normalize(pin); ok {
+			return code, nil
+		}
+		if _, err := conn.command("SETERROR " + assuanEscape("MFA code must be 6 digits.")); err != nil {
+			return "", err
+		}
+	}
+}
+
+type assuanError struct {
+	Code    int
+	Message string
+}
+
+func (e *assuanError) Error() string {
+	return fmt.Sprintf("pinentry: %s (%d)", e.Message, e.Code)
+}
+
+func (e *assuanError) Is(target error) bool {
+	return target == ErrCancelled && (e.Code&0xffff == gpgErrCanceled || e.Code&0xffff == gpgErrTimeout)
+}
+
+type assuanConn struct {
+	w io.Writer
+	r *bufio.Reader
+}
+
+func (c *assuanConn) command(line string) (string, error) {
+	if _, err := io.WriteString(c.w, line+"\n"); err != nil {
+		return "", fmt.Errorf("pinentry: %w", err)
+	}
+	return c.readResponse()
+}
+
+// readResponse reads lines up to the closing OK or ERR and returns the
+// decoded data lines.
+func (c *assuanConn) readResponse() (string, error) {
+	var data strings.Builder
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return "", errors.New("pinentry: unexpected end of output")
+			}
+			return "", fmt.Errorf("pinentry: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data.String(), nil
+		case strings.HasPrefix(line, "ERR "):
+			return "", parseAssuanError(strings.TrimPrefix(line, "ERR "))
+		case strings.HasPrefix(line, "D "):
+			decoded, err := url.PathUnescape(strings.TrimPrefix(line, "D "))
+			if err != nil {
+				return "", fmt.Errorf("pinentry: %w", err)
+			}
+			data.WriteString(decoded)
+		}
+		// Status (S) and comment (#) lines carry nothing we need.
+	}
+}
+
+func parseAssuanError(rest string) error {
+	codeText, message, _ := strings.Cut(rest, " ")
+	code, err := strconv.Atoi(codeText)
+	if err != nil {
+		return fmt.Errorf("pinentry: %s", rest)
+	}
+	return &assuanError{Code: code, Message: message}
+}
+
+func assuanEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
@@ -0,0 +1,179 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scizorman/op-aws-credential-process/internal/testutil"
+)
+
+// fakePinentry answers GETPIN with the given responses in turn, recording
+// every command it receives in the returned log file.
+func fakePinentry(t *testing.T, responses ...string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "commands.log")
+	countPath := filepath.Join(dir, "count")
+
+	var cases strings.Builder
+	for i, resp := range responses {
+		cases.WriteString("      " + string(rune('1'+i)) + ") printf '%s\\n' '" + resp + "' ;;\n")
+	}
+	script := `log='` + logPath + `'
+count='` + countPath + `'
+echo "OK Pleased to meet you"
+while read -r line; do
+  echo "$line" >> "$log"
+  case "$line" in
+  GETPIN)
+    n=$(( $(cat "$count" 2>/dev/null || echo 0) + 1 ))
+    echo "$n" > "$count"
+    case "$n" in
+` + cases.String() + `    esac
+    echo OK
+    ;;
+  BYE) echo "OK closing connection"; exit 0 ;;
+  *) echo OK ;;
+  esac
+done
+`
+	return testutil.WriteCommand(t, "pinentry", script), logPath
+}
+
+func readCommandLog(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestPinentrySource(t *testing.T) {
+	program, logPath := fakePinentry(t, "D 123 456")
+	source := &PinentrySource{
+		Program:   program,
+		Profile:   "test-profile",
+		MfaSerial: "arn:aws:iam::123456789012:mfa/user",
+		Timeout:   time.Minute,
+	}
+
+	code, err := source.OTP(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code != "123456" {
+		t.Errorf("code = %q, want %q", code, "123456")
+	}
+
+	commands := readCommandLog(t, logPath)
+	for _, want := range []string{
+		"SETDESC Enter MFA code for test-profile (arn:aws:iam::123456789012:mfa/user)\n",
+		"SETTIMEOUT 60\n",
+		"GETPIN\n",
+		"BYE\n",
+	} {
+		if !strings.Contains(commands, want) {
+			t.Errorf("commands = %q, want %q", commands, want)
+		}
+	}
+}
+
+func TestPinentrySource_RepromptsOnMalformedInput(t *testing.T) {
+	program, logPath := fakePinentry(t, "D 12%2534", "D 654321")
+	source := &PinentrySource{Program: program}
+
+	code, err := source.OTP(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code != "654321" {
+		t.Errorf("code = %q, want %q", code, "654321")
+	}
+
+	commands := readCommandLog(t, logPath)
+	if got := strings.Count(commands, "GETPIN\n"); got != 2 {
+		t.Errorf("GETPIN sent %d times, want 2", got)
+	}
+	if !strings.Contains(commands, "SETERROR MFA code must be 6 digits.\n") {
+		t.Errorf("commands = %q, want SETERROR", commands)
+	}
+}
+
+func TestPinentrySource_Cancelled(t *testing.T) {
+	program := testutil.WriteCommand(t, "pinentry", `echo "OK Pleased to meet you"
+while read -r line; do
+  case "$line" in
+  GETPIN) echo "ERR 83886179 Operation cancelled <Pinentry>" ;;
+  *) echo OK ;;
+  esac
+done
+`)
+	source := &PinentrySource{Program: program}
+
+	_, err := source.OTP(context.Background())
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("err = %v, want ErrCancelled", err)
+	}
+}
+
+func TestPinentrySource_Timeout(t *testing.T) {
+	program := testutil.WriteCommand(t, "pinentry", `echo "OK Pleased to meet you"
+exec sleep 10
+`)
+	source := &PinentrySource{Program: program, Timeout: 100 * time.Millisecond}
+
+	_, err := source.OTP(context.Background())
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("err = %v, want ErrCancelled", err)
+	}
+}
+
+func TestPinentrySource_ProgramNotFound(t *testing.T) {
+	source := &PinentrySource{Program: filepath.Join(t.TempDir(), "missing")}
+
+	if _, err := source.OTP(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestAutoSource_FallsBackToPinentry(t *testing.T) {
+	program, _ := fakePinentry(t, "D 123456")
+	source := &AutoSource{
+		TTY: &TTYSource{
+			Open: func() (Terminal, error) {
+				return nil, errors.New("open /dev/tty: no such device or address")
+			},
+		},
+		Pinentry: &PinentrySource{Program: program},
+	}
+
+	code, err := source.OTP(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code != "123456" {
+		t.Errorf("code = %q, want %q", code, "123456")
+	}
+}
+
+func TestAutoSource_PrefersTTY(t *testing.T) {
+	tty := &fakeTerminal{inputs: []string{"111111"}}
+	source := &AutoSource{
+		TTY:      newTTYSource(tty),
+		Pinentry: &PinentrySource{Program: filepath.Join(t.TempDir(), "missing")},
+	}
+
+	code, err := source.OTP(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code != "111111" {
+		t.Errorf("code = %q, want %q", code, "111111")
+	}
+}
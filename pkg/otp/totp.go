@@ -0,0 +1,137 @@
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TOTPKey holds the parameters of an RFC 6238 time-based one-time password.
+type TOTPKey struct {
+	Secret    []byte
+	Algorithm string
+	Digits    int
+	Period    time.Duration
+}
+
+// ParseTOTPKey accepts either an otpauth://totp/ URI or a bare base32 secret,
+// which gets the defaults every authenticator app assumes: SHA1, 6 digits and
+// 30 seconds.
+func ParseTOTPKey(s string) (TOTPKey, error) {
+	key := TOTPKey{
+		Algorithm: "SHA1",
+		Digits:    6,
+		Period:    DefaultTimeStep,
+	}
+
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(strings.ToLower(s), "otpauth://") {
+		secret, err := decodeTOTPSecret(s)
+		if err != nil {
+			return TOTPKey{}, err
+		}
+		key.Secret = secret
+		return key, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return TOTPKey{}, fmt.Errorf("invalid otpauth URI: %w", err)
+	}
+	if u.Host != "totp" {
+		return TOTPKey{}, fmt.Errorf("unsupported otpauth type %q", u.Host)
+	}
+	query := u.Query()
+
+	key.Secret, err = decodeTOTPSecret(query.Get("secret"))
+	if err != nil {
+		return TOTPKey{}, err
+	}
+	if v := query.Get("algorithm"); v != "" {
+		key.Algorithm = strings.ToUpper(v)
+		if totpHash(key.Algorithm) == nil {
+			return TOTPKey{}, fmt.Errorf("unsupported TOTP algorithm %q", v)
+		}
+	}
+	if v := query.Get("digits"); v != "" {
+		key.Digits, err = strconv.Atoi(v)
+		if err != nil || key.Digits < 6 || key.Digits > 10 {
+			return TOTPKey{}, fmt.Errorf("invalid TOTP digits %q", v)
+		}
+	}
+	if v := query.Get("period"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			return TOTPKey{}, fmt.Errorf("invalid TOTP period %q", v)
+		}
+		key.Period = time.Duration(seconds) * time.Second
+	}
+	return key, nil
+}
+
+func decodeTOTPSecret(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.Join(strings.Fields(s), ""))
+	s = strings.TrimRight(s, "=")
+	if s == "" {
+		return nil, errors.New("empty TOTP secret")
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base32 TOTP secret: %w", err)
+	}
+	return secret, nil
+}
+
+func totpHash(algorithm string) func() hash.Hash {
+	switch algorithm {
+	case "SHA1":
+		return sha1.New
+	case "SHA256":
+		return sha256.New
+	case "SHA512":
+		return sha512.New
+	}
+	return nil
+}
+
+// Code computes the code for the time step containing t, using the dynamic
+// truncation from RFC 4226. It fails for a key not made by ParseTOTPKey whose
+// algorithm, digits or period are unsupported.
+func (k TOTPKey) Code(t time.Time) (string, error) {
+	newHash := totpHash(k.Algorithm)
+	if newHash == nil {
+		return "", fmt.Errorf("unsupported TOTP algorithm %q", k.Algorithm)
+	}
+	if k.Digits < 6 || k.Digits > 10 {
+		return "", fmt.Errorf("invalid TOTP digits %d", k.Digits)
+	}
+	if k.Period < time.Second {
+		return "", fmt.Errorf("invalid TOTP period %s", k.Period)
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/int64(k.Period/time.Second)))
+
+	mac := hmac.New(newHash, k.Secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := uint64(binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff)
+
+	mod := uint64(1)
+	for range k.Digits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", k.Digits, value%mod), nil
+}
@@ -0,0 +1,112 @@
+package otp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTOTPKeyCode_RFC6238(t *testing.T) {
+	keys := map[string]TOTPKey{
+		"SHA1":   {Secret: []byte("12345678901234567890"), Algorithm: "SHA1", Digits: 8, Period: 30 * time.Second},
+		"SHA256": {Secret: []byte("12345678901234567890123456789012"), Algorithm: "SHA256", Digits: 8, Period: 30 * time.Second},
+		"SHA512": {Secret: []byte("1234567890123456789012345678901234567890123456789012345678901234"), Algorithm: "SHA512", Digits: 8, Period: 30 * time.Second},
+	}
+	tests := []struct {
+		unix      int64
+		algorithm string
+		want      string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1111111109, "SHA512", "25091201"},
+		{1111111111, "SHA1", "14050471"},
+		{1111111111, "SHA256", "67062674"},
+		{1111111111, "SHA512", "99943326"},
+		{1234567890, "SHA1", "89005924"},
+		{1234567890, "SHA256", "91819424"},
+		{1234567890, "SHA512", "93441116"},
+		{2000000000, "SHA1", "69279037"},
+		{2000000000, "SHA256", "90698825"},
+		{2000000000, "SHA512", "38618901"},
+		{20000000000, "SHA1", "65353130"},
+		{20000000000, "SHA256", "77737706"},
+		{20000000000, "SHA512", "47863826"},
+	}
+
+	for _, tt := range tests {
+		got, err := keys[tt.algorithm].Code(time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("%s at %d = %s, want %s", tt.algorithm, tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPKey_CodeInvalid(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	tests := map[string]TOTPKey{
+		"zero value":        {},
+		"unknown algorithm": {Secret: secret, Algorithm: "MD5", Digits: 6, Period: 30 * time.Second},
+		"zero period":       {Secret: secret, Algorithm: "SHA1", Digits: 6},
+		"sub-second period": {Secret: secret, Algorithm: "SHA1", Digits: 6, Period: 500 * time.Millisecond},
+		"too many digits":   {Secret: secret, Algorithm: "SHA1", Digits: 20, Period: 30 * time.Second},
+	}
+	for name, key := range tests {
+		t.Run(name, func(t *testing.T) {
+			if code, err := key.Code(time.Unix(59, 0)); err == nil {
+				t.Errorf("Code() = %q, want an error", code)
+			}
+		})
+	}
+}
+
+func TestParseTOTPKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    TOTPKey
+		wantErr bool
+	}{
+		{
+			name:  "bare secret",
+			input: "gezd gnbv gy3t qojq gezd gnbv gy3t qojq",
+			want:  TOTPKey{Secret: []byte("12345678901234567890"), Algorithm: "SHA1", Digits: 6, Period: 30 * time.Second},
+		},
+		{
+			name:  "otpauth URI",
+			input: "otpauth://totp/Amazon%20Web%20Services:user@123456789012?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&issuer=Amazon%20Web%20Services&algorithm=SHA256&digits=8&period=60",
+			want:  TOTPKey{Secret: []byte("12345678901234567890"), Algorithm: "SHA256", Digits: 8, Period: time.Minute},
+		},
+		{
+			name:  "otpauth URI with defaults",
+			input: "otpauth://totp/AWS?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+			want:  TOTPKey{Secret: []byte("12345678901234567890"), Algorithm: "SHA1", Digits: 6, Period: 30 * time.Second},
+		},
+		{name: "hotp", input: "otpauth://hotp/AWS?secret=GEZDGNBVGY3TQOJQ&counter=1", wantErr: true},
+		{name: "unsupported algorithm", input: "otpauth://totp/AWS?secret=GEZDGNBVGY3TQOJQ&algorithm=MD5", wantErr: true},
+		{name: "invalid digits", input: "otpauth://totp/AWS?secret=GEZDGNBVGY3TQOJQ&digits=4", wantErr: true},
+		{name: "invalid period", input: "otpauth://totp/AWS?secret=GEZDGNBVGY3TQOJQ&period=0", wantErr: true},
+		{name: "missing secret", input: "otpauth://totp/AWS", wantErr: true},
+		{name: "invalid base32", input: "not base32!", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTOTPKey(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if string(got.Secret) != string(tt.want.Secret) || got.Algorithm != tt.want.Algorithm || got.Digits != tt.want.Digits || got.Period != tt.want.Period {
+				t.Errorf("key = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Terminal is the part of a tty the MFA prompt needs; input is read without
+// echo. Close must make a pending ReadPassword return, so that an abandoned
+// prompt does not leave its reader behind.
+type Terminal interface {
+	io.Writer
+	ReadPassword() (string, error)
+	Close() error
+}
+
+// ErrNoTTY is returned by TTYSource when the process has no controlling
+// terminal.
+var ErrNoTTY = errors.New("no terminal available for the MFA prompt")
+
+// TTYSource prompts for the code on the controlling terminal with echo
+// disabled, asking again until six digits are entered. Open defaults to
+// /dev/tty.
+type TTYSource struct {
+	Profile   string
+	MfaSerial string
+	Timeout   time.Duration
+	Open      func() (Terminal, error)
+}
+
+func (s *TTYSource) OTP(ctx context.Context) (string, error) {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	open := s.Open
+	if open == nil {
+		open = openTTY
+	}
+	tty, err := open()
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrNoTTY, err)
+	}
+
+	type result struct {
+		code string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		code, err := s.prompt(tty)
+		done <- result{code, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		_, _ = fmt.Fprintln(tty)
+		_ = tty.Close()
+		<-done
+		return "", promptCancelled(ctx, s.Timeout)
+	case r := <-done:
+		_ = tty.Close()
+		return r.code, r.err
+	}
+}
+
+func (s *TTYSource) prompt(tty Terminal) (string, error) {
+	for {
+		if _, err := fmt.Fprintf(tty, "Enter MFA code for %s (%s): ", s.Profile, s.MfaSerial); err != nil {
+			return "", err
+		}
+		line, err := tty.ReadPassword()
+		// Echo is off, so the newline typed by the user is not shown either.
+		_, _ = fmt.Fprintln(tty)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return "", ErrCancelled
+			}
+			return "", err
+		}
+
+		if code, ok := Normalize(line); ok {
+			return code, nil
+		}
+		if _, err := fmt.Fprintln(tty, "MFA code must be 6 digits."); err != nil {
+			return "", err
+		}
+	}
+}
+
+// AutoSource prompts on the terminal and falls back to pinentry when the
+// process has no controlling terminal.
+type AutoSource struct {
+	TTY      *TTYSource
+	Pinentry *PinentrySource
+}
+
+func (s *AutoSource) OTP(ctx context.Context) (string, error) {
+	code, err := s.TTY.OTP(ctx)
+	if errors.Is(err, ErrNoTTY) {
+		slog.DebugContext(ctx, "no terminal for the MFA prompt; using pinentry", "program", s.Pinentry.Program, "error", err)
+		return s.Pinentry.OTP(ctx)
+	}
+	return code, err
+}
@@ -0,0 +1,112 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY returns the controlling and the terminal side of a new
+// pseudo-terminal.
+func openPTY(t *testing.T) (*os.File, *os.File) {
+	t.Helper()
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = ptmx.Close()
+	})
+
+	conn, err := ptmx.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	err = conn.Control(func(fd uintptr) {
+		if err = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); err != nil {
+			return
+		}
+		n, err = unix.IoctlGetInt(int(fd), unix.TIOCGPTN)
+	})
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+
+	pts, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	return ptmx, pts
+}
+
+func TestTTYTerminal_ReadPassword(t *testing.T) {
+	ptmx, pts := openPTY(t)
+	tty, err := newTTYTerminal(pts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer tty.Close()
+
+	if _, err := ptmx.WriteString("123 456\n"); err != nil {
+		t.Fatal(err)
+	}
+	line, err := tty.ReadPassword()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if line != "123 456" {
+		t.Errorf("line = %q, want %q", line, "123 456")
+	}
+}
+
+// readSignal reports when ReadPassword of the wrapped terminal returns.
+type readSignal struct {
+	Terminal
+	done chan struct{}
+}
+
+func (r *readSignal) ReadPassword() (string, error) {
+	defer close(r.done)
+	return r.Terminal.ReadPassword()
+}
+
+func TestTTYSource_TimeoutEndsRead(t *testing.T) {
+	_, pts := openPTY(t)
+	tty, err := newTTYTerminal(pts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reader := &readSignal{Terminal: tty, done: make(chan struct{})}
+	source := &TTYSource{
+		Timeout: 50 * time.Millisecond,
+		Open: func() (Terminal, error) {
+			return reader, nil
+		},
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := source.OTP(context.Background())
+		errc <- err
+	}()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrCancelled) {
+			t.Errorf("err = %v, want ErrCancelled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OTP did not return after the timeout")
+	}
+	select {
+	case <-reader.done:
+	default:
+		t.Error("reader is still blocked after the timeout")
+	}
+}
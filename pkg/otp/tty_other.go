@@ -0,0 +1,12 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package otp
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func openTTY() (Terminal, error) {
+	return nil, fmt.Errorf("no terminal prompt on %s", runtime.GOOS)
+}
@@ -0,0 +1,116 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package otp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ttyTerminal reaches the descriptor of f only through SyscallConn: Fd would
+// switch it to blocking mode, and Close could then no longer interrupt a
+// pending read.
+type ttyTerminal struct {
+	f     *os.File
+	conn  syscall.RawConn
+	state unix.Termios
+}
+
+func openTTY() (Terminal, error) {
+	f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newTTYTerminal(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func newTTYTerminal(f *os.File) (*ttyTerminal, error) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	t := &ttyTerminal{f: f, conn: conn}
+	err = t.control(func(fd int) error {
+		state, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+		if err != nil {
+			return err
+		}
+		t.state = *state
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *ttyTerminal) control(fn func(fd int) error) error {
+	var err error
+	if cerr := t.conn.Control(func(fd uintptr) { err = fn(int(fd)) }); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+func (t *ttyTerminal) setState(state *unix.Termios) error {
+	return t.control(func(fd int) error {
+		return unix.IoctlSetTermios(fd, ioctlSetTermios, state)
+	})
+}
+
+func (t *ttyTerminal) Write(p []byte) (int, error) {
+	return t.f.Write(p)
+}
+
+// ReadPassword reads a line with echo disabled, keeping the kernel's line
+// editing as term.ReadPassword does.
+func (t *ttyTerminal) ReadPassword() (string, error) {
+	noEcho := t.state
+	noEcho.Lflag &^= unix.ECHO
+	noEcho.Lflag |= unix.ICANON | unix.ISIG
+	noEcho.Iflag |= unix.ICRNL
+	if err := t.setState(&noEcho); err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = t.setState(&t.state)
+	}()
+
+	var line []byte
+	var buf [1]byte
+	for {
+		n, err := t.f.Read(buf[:])
+		if n > 0 {
+			switch buf[0] {
+			case '\n':
+				return string(line), nil
+			case '\r':
+			default:
+				line = append(line, buf[0])
+			}
+			continue
+		}
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			return string(line), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// Close restores the terminal state, which matters when the prompt is
+// abandoned while echo is still disabled, and interrupts a pending read.
+func (t *ttyTerminal) Close() error {
+	_ = t.setState(&t.state)
+	return t.f.Close()
+}
@@ -0,0 +1,107 @@
+package otp
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/scizorman/op-aws-credential-process/internal/testutil"
+)
+
+func TestYkmanSource(t *testing.T) {
+	program := testutil.WriteCommand(t, "ykman", `if [ "$*" != "oath accounts code --single Amazon Web Services:user@123456789012" ]; then
+  echo "unexpected args: $*" >&2
+  exit 2
+fi
+echo 123456
+`)
+	t.Setenv("PATH", filepath.Dir(program))
+
+	var stderr bytes.Buffer
+	source := &YkmanSource{
+		Path:    "ykman",
+		Account: "Amazon Web Services:user@123456789012",
+		Stderr:  &stderr,
+	}
+
+	code, err := source.OTP(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code != "123456" {
+		t.Errorf("code = %q, want %q", code, "123456")
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("stderr = %q, want nothing from ykman", stderr.String())
+	}
+}
+
+func TestYkmanSource_TouchPrompt(t *testing.T) {
+	program := testutil.WriteCommand(t, "ykman", `echo "Touch your YubiKey..." >&2
+echo 123456
+`)
+	var stderr bytes.Buffer
+	source := &YkmanSource{Path: program, Account: "aws", Stderr: &stderr}
+
+	if _, err := source.OTP(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := stderr.String(); got != "Touch your YubiKey...\n" {
+		t.Errorf("stderr = %q, want the touch prompt of ykman", got)
+	}
+}
+
+func TestYkmanSource_Error(t *testing.T) {
+	program := testutil.WriteCommand(t, "ykman", `echo "ERROR: No YubiKey detected!" >&2
+exit 1
+`)
+	var stderr bytes.Buffer
+	source := &YkmanSource{Path: program, Account: "aws", Stderr: &stderr}
+
+	_, err := source.OTP(context.Background())
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "No YubiKey detected") {
+		t.Errorf("err = %v, want ykman stderr", err)
+	}
+	if !strings.Contains(stderr.String(), "No YubiKey detected") {
+		t.Errorf("stderr = %q, want ykman stderr", stderr.String())
+	}
+}
+
+func TestYkmanSource_MissingAccount(t *testing.T) {
+	source := &YkmanSource{Path: "ykman", Stderr: &bytes.Buffer{}}
+
+	if _, err := source.OTP(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestParseYkmanCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    string
+		wantErr bool
+	}{
+		{name: "six digits", out: "123456\n", want: "123456"},
+		{name: "eight digits", out: "12345678\n", want: "12345678"},
+		{name: "empty", out: "", wantErr: true},
+		{name: "not a code", out: "[Requires Touch]\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYkmanCode([]byte(tt.out), "AWS:user")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("code = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+// Package passcreds reads AWS access keys and MFA codes from a password-store
+// entry through pass or gopass.
+package passcreds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+	"github.com/scizorman/op-aws-credential-process/pkg/otp"
+)
+
+const (
+	Name           = "pass"
+	DefaultCLIPath = "pass"
+
+	// PasswordField names the first line of an entry, where pass keeps the
+	// password.
+	PasswordField = "password"
+)
+
+// CredentialSource is a backend.Backend returning the long-term access key
+// stored in a pass entry. By default the secret access key is the first
+// line and the access key ID is an "Access key ID: ..." line; keys are
+// matched case-insensitively. Vault is not used.
+type CredentialSource struct {
+	CLIPath string
+	backend.Item
+}
+
+// NewCredentialSource returns a source for the entry at path, using the
+// default pass path and keys unless optFns change them. Set CLIPath to gopass
+// to read from gopass.
+func NewCredentialSource(path string, optFns ...func(*CredentialSource)) *CredentialSource {
+	s := &CredentialSource{
+		CLIPath: DefaultCLIPath,
+		Item: backend.Item{
+			Item:                 path,
+			AccessKeyIDField:     backend.DefaultAccessKeyIDField,
+			SecretAccessKeyField: PasswordField,
+		},
+	}
+	for _, fn := range optFns {
+		fn(s)
+	}
+	return s
+}
+
+func (s *CredentialSource) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	e, err := show(ctx, s.CLIPath, s.Item.Item)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	creds := aws.Credentials{
+		AccessKeyID:     e.field(s.AccessKeyIDField),
+		SecretAccessKey: e.field(s.SecretAccessKeyField),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return aws.Credentials{}, &backend.Error{Backend: Name, Command: "show", Err: errors.New("missing credentials in pass entry")}
+	}
+	return creds, nil
+}
+
+func (s *CredentialSource) Name() string {
+	return Name
+}
+
+// OTPSource returns a source computing the code from the otpauth:// URI of
+// the same entry.
+func (s *CredentialSource) OTPSource() otp.TimeStepSource {
+	return NewOTPSource(s.Item.Item, func(o *OTPSource) {
+		o.CLIPath = s.CLIPath
+	})
+}
+
+// entry is a pass entry split into the password on the first line, the
+// "key: value" lines below it, and an otpauth:// URI on a line of its own as
+// written by pass-otp.
+type entry struct {
+	password string
+	fields   map[string]string
+	otpauth  string
+}
+
+func parseEntry(data string) entry {
+	lines := strings.Split(strings.TrimRight(data, "\n"), "\n")
+	e := entry{
+		password: strings.TrimSpace(lines[0]),
+		fields:   make(map[string]string),
+	}
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "otpauth://") {
+			e.otpauth = line
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if _, dup := e.fields[key]; !dup {
+			e.fields[key] = strings.TrimSpace(value)
+		}
+	}
+	return e
+}
+
+func (e entry) field(name string) string {
+	if strings.EqualFold(name, PasswordField) {
+		return e.password
+	}
+	return e.fields[strings.ToLower(name)]
+}
+
+func show(ctx context.Context, cliPath, path string) (entry, error) {
+	cmd := exec.CommandContext(ctx, cliPath, "show", path)
+	slog.DebugContext(ctx, "running pass show", "entry", path)
+	start := time.Now()
+	out, err := cmd.Output()
+	if err != nil {
+		slog.DebugContext(ctx, "pass show failed", "elapsed", time.Since(start), "error", err)
+		return entry{}, backend.NewError(Name, "show", err)
+	}
+	slog.InfoContext(ctx, "pass show succeeded", "entry", path, "elapsed", time.Since(start))
+	return parseEntry(string(out)), nil
+}
+
+// OTPSource computes the code locally from the otpauth:// URI in a pass
+// entry.
+type OTPSource struct {
+	CLIPath string
+	Path    string
+	Now     func() time.Time
+
+	mu     sync.Mutex
+	period time.Duration
+}
+
+func NewOTPSource(path string, optFns ...func(*OTPSource)) *OTPSource {
+	s := &OTPSource{CLIPath: DefaultCLIPath, Path: path}
+	for _, fn := range optFns {
+		fn(s)
+	}
+	return s
+}
+
+func (s *OTPSource) OTP(ctx context.Context) (string, error) {
+	e, err := show(ctx, s.CLIPath, s.Path)
+	if err != nil {
+		return "", err
+	}
+	if e.otpauth == "" {
+		return "", &backend.Error{Backend: Name, Command: "show", Err: errors.New("no otpauth:// URI in pass entry")}
+	}
+
+	key, err := otp.ParseTOTPKey(e.otpauth)
+	if err != nil {
+		return "", fmt.Errorf("pass entry %s: %w", s.Path, err)
+	}
+
+	s.mu.Lock()
+	s.period = key.Period
+	s.mu.Unlock()
+
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+	return key.Code(now)
+}
+
+// TimeStep reports the period of the last URI read, which is the default
+// until the first code has been computed.
+func (s *OTPSource) TimeStep() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.period == 0 {
+		return otp.DefaultTimeStep
+	}
+	return s.period
+}
@@ -0,0 +1,104 @@
+package passcreds
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/scizorman/op-aws-credential-process/internal/testutil"
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+	"github.com/scizorman/op-aws-credential-process/pkg/otp"
+)
+
+var _ backend.OTPBackend = (*CredentialSource)(nil)
+
+// fakePass writes a pass stand-in printing entry for aws/dev. GNUPGHOME
+// points at an empty directory, so the tests cannot reach a real keyring.
+func fakePass(t *testing.T, entry string) string {
+	t.Helper()
+	t.Setenv("GNUPGHOME", t.TempDir())
+	return testutil.WriteCommand(t, "pass", `if [ "$*" != "show aws/dev" ]; then
+  echo "Error: $2 is not in the password store." >&2
+  exit 1
+fi
+cat <<'ENTRY'
+`+entry+`ENTRY
+`)
+}
+
+func TestCredentialSource(t *testing.T) {
+	program := fakePass(t, `secret
+access key id: AKIAEXAMPLE
+url: https://console.aws.amazon.com/
+otpauth://totp/AWS?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ
+`)
+	source := NewCredentialSource("aws/dev", func(s *CredentialSource) {
+		s.CLIPath = program
+	})
+
+	creds, err := source.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if creds.AccessKeyID != "AKIAEXAMPLE" || creds.SecretAccessKey != "secret" {
+		t.Errorf("creds = %+v, want AKIAEXAMPLE/secret", creds)
+	}
+}
+
+func TestCredentialSource_Error(t *testing.T) {
+	program := fakePass(t, "")
+	source := NewCredentialSource("aws/missing", func(s *CredentialSource) {
+		s.CLIPath = program
+	})
+
+	_, err := source.Retrieve(context.Background())
+	passErr, ok := errors.AsType[*backend.Error](err)
+	if !ok {
+		t.Fatalf("err = %v, want *backend.Error", err)
+	}
+	if passErr.Stderr != "Error: aws/missing is not in the password store." {
+		t.Errorf("Stderr = %q", passErr.Stderr)
+	}
+}
+
+func TestOTPSource(t *testing.T) {
+	program := fakePass(t, `secret
+Access key ID: AKIAEXAMPLE
+otpauth://totp/AWS?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=8&period=60
+`)
+	source := NewOTPSource("aws/dev", func(s *OTPSource) {
+		s.CLIPath = program
+		s.Now = func() time.Time {
+			return time.Unix(1111111111, 0)
+		}
+	})
+
+	code, err := source.OTP(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want, err := (otp.TOTPKey{Secret: []byte("12345678901234567890"), Algorithm: "SHA1", Digits: 8, Period: time.Minute}).Code(time.Unix(1111111111, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != want {
+		t.Errorf("code = %q, want %q", code, want)
+	}
+	if got := source.TimeStep(); got != time.Minute {
+		t.Errorf("TimeStep = %v, want %v", got, time.Minute)
+	}
+}
+
+func TestOTPSource_NoURI(t *testing.T) {
+	program := fakePass(t, `secret
+Access key ID: AKIAEXAMPLE
+`)
+	source := NewOTPSource("aws/dev", func(s *OTPSource) {
+		s.CLIPath = program
+	})
+
+	if _, err := source.OTP(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,39 @@
+package sessioncache_test
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/opcreds"
+	"github.com/scizorman/op-aws-credential-process/pkg/otp"
+	"github.com/scizorman/op-aws-credential-process/pkg/sessioncache"
+	"github.com/scizorman/op-aws-credential-process/pkg/stssession"
+)
+
+func Example() {
+	ctx := context.Background()
+	serial := "arn:aws:iam::123456789012:mfa/alice"
+
+	base := opcreds.NewCredentialSource("Private", "AWS")
+	stsClient := sts.New(sts.Options{Region: "us-east-1", Credentials: base})
+	session := stssession.NewSessionTokenProvider(stsClient, base, &otp.TTYSource{Profile: "dev", MfaSerial: serial}, serial)
+
+	cache, err := sessioncache.New(session, "dev", func(p *sessioncache.Provider) {
+		p.Backend = base.Name()
+		p.Item = base.Item
+		p.MfaSerial = serial
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithCredentialsProvider(aws.NewCredentialsCache(cache)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	_ = sts.NewFromConfig(cfg)
+}
@@ -0,0 +1,439 @@
+// Package sessioncache caches STS session credentials on disk between
+// credential_process invocations.
+package sessioncache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/audit"
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+	"github.com/scizorman/op-aws-credential-process/pkg/stssession"
+)
+
+// DefaultExpiryWindow is how long before their expiration cached
+// credentials are treated as expired.
+const DefaultExpiryWindow = 5 * time.Minute
+
+// Error reports a problem with the cache location.
+type Error struct {
+	Path string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("cache: %v", e.Err)
+	}
+	return fmt.Sprintf("cache %s: %v", e.Path, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// DefaultDir returns $XDG_CACHE_HOME, or ~/.cache when it is not set.
+func DefaultDir() (string, error) {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", &Error{Err: err}
+		}
+		dir = filepath.Join(home, ".cache")
+	}
+	return dir, nil
+}
+
+// New returns a Provider caching the credentials of provider for profile in
+// DefaultDir. optFns can set the parameters the cache is validated against
+// (backend, item, MFA serial, role ARN, session tags and policies), the
+// expiry and refresh windows, and an Auditor.
+func New(provider stssession.Provider, profile string, optFns ...func(*Provider)) (*Provider, error) {
+	dir, err := DefaultDir()
+	if err != nil {
+		return nil, err
+	}
+	c := &Provider{
+		SessionProvider: provider,
+		CacheDir:        dir,
+		Profile:         profile,
+		ExpiryWindow:    DefaultExpiryWindow,
+	}
+	for _, fn := range optFns {
+		fn(c)
+	}
+	return c, nil
+}
+
+// Provider caches the credentials of an stssession.Provider on disk, so the
+// MFA prompt is only needed when the session has expired or any of the
+// parameters it was issued for changed.
+type Provider struct {
+	SessionProvider stssession.Provider
+	CacheDir        string
+	Profile         string
+	ExpiryWindow    time.Duration
+	Backend         string
+	Item            backend.Item
+	MfaSerial       string
+	RoleArn         string
+	Now             func() time.Time
+
+	// Tags, TransitiveTagKeys and SourceIdentity are the session tags and
+	// source identity the session is requested with. Sessions that differ in
+	// them are cached in separate files.
+	Tags              map[string]string
+	TransitiveTagKeys []string
+	SourceIdentity    string
+
+	// FederationName is the federated user of a GetFederationToken session.
+	FederationName string
+	// Policy and PolicyArns are the session policies the session is scoped
+	// down by. Only their digest is kept in the cache, and sessions with
+	// different policies are cached in separate files.
+	Policy     string
+	PolicyArns []string
+
+	RefreshWindow     time.Duration
+	BackgroundRefresh func() error
+
+	Audit audit.Auditor
+	// Caller is recorded in audit records as the process the credentials
+	// are returned to.
+	Caller audit.Caller
+}
+
+// CachePath returns <CacheDir>/op-aws-credential-process/<Profile>.json, or
+// <Profile>-<digest>.json when the session has tags, a source identity, a
+// federated user name or session policies.
+func (c *Provider) CachePath() string {
+	name := c.Profile
+	if digest := c.sessionDigest(); digest != "" {
+		name += "-" + digest
+	}
+	return filepath.Join(c.CacheDir, "op-aws-credential-process", name+".json")
+}
+
+// sessionDigest abbreviates a SHA-256 of the session tags, source identity,
+// federated user name and policies, or returns "" when there are none.
+func (c *Provider) sessionDigest() string {
+	policyDigest := c.policyDigest()
+	if len(c.Tags) == 0 && len(c.TransitiveTagKeys) == 0 && c.SourceIdentity == "" && c.FederationName == "" && policyDigest == "" {
+		return ""
+	}
+	data, _ := json.Marshal(struct {
+		Tags              map[string]string `json:"tags"`
+		TransitiveTagKeys []string          `json:"transitive_tag_keys"`
+		SourceIdentity    string            `json:"source_identity"`
+		FederationName    string            `json:"federation_name,omitempty"`
+		PolicyDigest      string            `json:"policy_digest,omitempty"`
+	}{c.Tags, slices.Sorted(slices.Values(c.TransitiveTagKeys)), c.SourceIdentity, c.FederationName, policyDigest})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// policyDigest is a SHA-256 of the session policies, or "" when there are
+// none. The inline policy is compacted first, so reformatting it does not
+// change the digest.
+func (c *Provider) policyDigest() string {
+	if c.Policy == "" && len(c.PolicyArns) == 0 {
+		return ""
+	}
+	policy := []byte(c.Policy)
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, policy); err == nil {
+		policy = compact.Bytes()
+	}
+	data, _ := json.Marshal(struct {
+		Policy     string   `json:"policy"`
+		PolicyArns []string `json:"policy_arns"`
+	}{string(policy), slices.Sorted(slices.Values(c.PolicyArns))})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Provider) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+// backend returns the Backend, which is backend.DefaultName when unset since
+// entries written before other backends existed have none.
+func (c *Provider) backend() string {
+	if c.Backend == "" {
+		return backend.DefaultName
+	}
+	return c.Backend
+}
+
+func (c *Provider) invalidReason(entry cachedEntry) string {
+	if entry.Credentials == nil || entry.Credentials.Expiration == nil {
+		return "missing credentials"
+	}
+	if entry.backend() != c.backend() {
+		return "backend changed"
+	}
+	if entry.Vault != c.Item.Vault {
+		return "vault changed"
+	}
+	if entry.Item != c.Item.Item {
+		return "item changed"
+	}
+	if entry.MfaSerial != c.MfaSerial {
+		return "mfa_serial changed"
+	}
+	if entry.RoleArn != c.RoleArn {
+		return "role_arn changed"
+	}
+	if !maps.Equal(entry.Tags, c.Tags) || !sameKeys(entry.TransitiveTagKeys, c.TransitiveTagKeys) {
+		return "session tags changed"
+	}
+	if entry.SourceIdentity != c.SourceIdentity {
+		return "source identity changed"
+	}
+	if entry.FederationName != c.FederationName {
+		return "federation name changed"
+	}
+	if entry.PolicyDigest != c.policyDigest() {
+		return "session policies changed"
+	}
+	if entry.AccessKeyIDField != c.Item.AccessKeyIDField {
+		return "access key ID field changed"
+	}
+	if entry.SecretAccessKeyField != c.Item.SecretAccessKeyField {
+		return "secret access key field changed"
+	}
+	if !c.now().Add(c.ExpiryWindow).Before(*entry.Credentials.Expiration) {
+		return "expired"
+	}
+	return ""
+}
+
+func (c *Provider) loadCache() (cachedEntry, string) {
+	data, err := os.ReadFile(c.CachePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return cachedEntry{}, "no cache file"
+	}
+	if err != nil {
+		return cachedEntry{}, err.Error()
+	}
+
+	var cached cachedEntry
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return cachedEntry{}, "corrupted cache file"
+	}
+	return cached, c.invalidReason(cached)
+}
+
+func (c *Provider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
+	creds, _, err := c.Fetch(ctx)
+	return creds, err
+}
+
+// Fetch is RetrieveStsCredentials that also reports whether the credentials
+// came from the cache.
+func (c *Provider) Fetch(ctx context.Context) (*ststypes.Credentials, bool, error) {
+	cached, reason := c.loadCache()
+	if reason == "" {
+		slog.DebugContext(ctx, "cache hit", "profile", c.Profile, "expiration", aws.ToTime(cached.Credentials.Expiration))
+		if c.isRefreshDue(cached) {
+			if err := c.BackgroundRefresh(); err != nil {
+				slog.DebugContext(ctx, "background refresh not started", "profile", c.Profile, "error", err)
+			} else {
+				slog.InfoContext(ctx, "background refresh started", "profile", c.Profile)
+			}
+		}
+		c.audit(ctx, cached.Credentials, true)
+		return cached.Credentials, true, nil
+	}
+	slog.DebugContext(ctx, "cache miss", "profile", c.Profile, "reason", reason)
+
+	creds, err := c.Renew(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return creds, false, nil
+}
+
+// Fresh reports whether creds are outside both the expiry and the refresh
+// window, so that a copy kept in memory can be served without the cache.
+func (c *Provider) Fresh(creds *ststypes.Credentials) bool {
+	if creds == nil || creds.Expiration == nil {
+		return false
+	}
+	return c.now().Add(max(c.ExpiryWindow, c.RefreshWindow)).Before(*creds.Expiration)
+}
+
+// AuditHit records creds served from a copy kept in memory as the cache hit
+// it stands in for.
+func (c *Provider) AuditHit(ctx context.Context, creds *ststypes.Credentials) {
+	c.audit(ctx, creds, true)
+}
+
+func (c *Provider) audit(ctx context.Context, creds *ststypes.Credentials, cacheHit bool) {
+	if c.Audit == nil {
+		return
+	}
+
+	var operation string
+	if p, ok := c.SessionProvider.(interface{ Operation() string }); ok {
+		operation = p.Operation()
+	}
+
+	err := c.Audit.Log(audit.Record{
+		Time:              c.now(),
+		Profile:           c.Profile,
+		Backend:           c.backend(),
+		Vault:             c.Item.Vault,
+		Item:              c.Item.Item,
+		MfaSerial:         c.MfaSerial,
+		RoleArn:           c.RoleArn,
+		Operation:         operation,
+		AccessKeyIDPrefix: audit.AccessKeyIDPrefix(aws.ToString(creds.AccessKeyId)),
+		Expiration:        aws.ToTime(creds.Expiration),
+		CacheHit:          cacheHit,
+		CallerKind:        c.Caller.Kind,
+		CallerPID:         c.Caller.PID,
+		CallerCommand:     c.Caller.Command,
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "failed to write audit log", "error", err)
+	}
+}
+
+func (c *Provider) isRefreshDue(entry cachedEntry) bool {
+	if c.BackgroundRefresh == nil || c.RefreshWindow <= 0 {
+		return false
+	}
+	return !c.now().Add(c.RefreshWindow).Before(*entry.Credentials.Expiration)
+}
+
+// Renew asks the SessionProvider for new credentials regardless of the
+// cache and stores them.
+func (c *Provider) Renew(ctx context.Context) (*ststypes.Credentials, error) {
+	creds, err := c.SessionProvider.RetrieveStsCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := cachedEntry{
+		Credentials:          creds,
+		Backend:              c.backend(),
+		Vault:                c.Item.Vault,
+		Item:                 c.Item.Item,
+		MfaSerial:            c.MfaSerial,
+		RoleArn:              c.RoleArn,
+		Tags:                 c.Tags,
+		TransitiveTagKeys:    c.TransitiveTagKeys,
+		SourceIdentity:       c.SourceIdentity,
+		FederationName:       c.FederationName,
+		PolicyDigest:         c.policyDigest(),
+		AccessKeyIDField:     c.Item.AccessKeyIDField,
+		SecretAccessKeyField: c.Item.SecretAccessKeyField,
+	}
+	// SourceIdentity may be "auto"; keep what it resolved to.
+	if p, ok := c.SessionProvider.(interface{ ResolvedSourceIdentity() string }); ok {
+		entry.ResolvedSourceIdentity = p.ResolvedSourceIdentity()
+	}
+	if err := c.writeCache(entry); err != nil {
+		slog.WarnContext(ctx, "failed to write cache", "path", c.CachePath(), "error", err)
+	}
+	c.audit(ctx, creds, false)
+
+	return creds, nil
+}
+
+// writeCache replaces the cache file through a rename, so that readers, such
+// as a background refresh racing a cache hit, never see it half written.
+func (c *Provider) writeCache(entry cachedEntry) error {
+	dir := filepath.Dir(c.CachePath())
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// CreateTemp makes the file with mode 0600.
+	f, err := os.CreateTemp(dir, filepath.Base(c.CachePath())+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.CachePath())
+}
+
+func (c *Provider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := c.RetrieveStsCredentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+		CanExpire:       true,
+		Expires:         aws.ToTime(creds.Expiration),
+	}, nil
+}
+
+type cachedEntry struct {
+	Credentials            *ststypes.Credentials `json:"credentials"`
+	Backend                string                `json:"backend,omitempty"`
+	Vault                  string                `json:"vault"`
+	Item                   string                `json:"item"`
+	MfaSerial              string                `json:"mfa_serial"`
+	RoleArn                string                `json:"role_arn,omitempty"`
+	Tags                   map[string]string     `json:"tags,omitempty"`
+	TransitiveTagKeys      []string              `json:"transitive_tag_keys,omitempty"`
+	SourceIdentity         string                `json:"source_identity,omitempty"`
+	ResolvedSourceIdentity string                `json:"resolved_source_identity,omitempty"`
+	FederationName         string                `json:"federation_name,omitempty"`
+	PolicyDigest           string                `json:"policy_digest,omitempty"`
+	AccessKeyIDField       string                `json:"access_key_id_field"`
+	SecretAccessKeyField   string                `json:"secret_access_key_field"`
+}
+
+func (e cachedEntry) backend() string {
+	if e.Backend == "" {
+		return backend.DefaultName
+	}
+	return e.Backend
+}
+
+func sameKeys(a, b []string) bool {
+	return slices.Equal(slices.Sorted(slices.Values(a)), slices.Sorted(slices.Values(b)))
+}
@@ -0,0 +1,674 @@
+package sessioncache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/audit"
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+	"github.com/scizorman/op-aws-credential-process/pkg/stssession"
+)
+
+type fakeStsSessionProvider struct {
+	creds     *ststypes.Credentials
+	err       error
+	called    int
+	operation string
+}
+
+func (f *fakeStsSessionProvider) Operation() string {
+	return f.operation
+}
+
+func (f *fakeStsSessionProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
+	f.called++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.creds, nil
+}
+
+func (f *fakeStsSessionProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := f.RetrieveStsCredentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+		CanExpire:       true,
+		Expires:         aws.ToTime(creds.Expiration),
+	}, nil
+}
+
+func newStsCreds(accessKey, secret, token string, expiration time.Time) *ststypes.Credentials {
+	return &ststypes.Credentials{
+		AccessKeyId:     aws.String(accessKey),
+		SecretAccessKey: aws.String(secret),
+		SessionToken:    aws.String(token),
+		Expiration:      aws.Time(expiration),
+	}
+}
+
+func defaultItem() backend.Item {
+	return backend.Item{
+		Vault:                "vault-a",
+		Item:                 "item-a",
+		AccessKeyIDField:     "username",
+		SecretAccessKeyField: "credential",
+	}
+}
+
+func readCachedEntry(t *testing.T, path string) cachedEntry {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read cache file: %v", err)
+	}
+	var entry cachedEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("failed to unmarshal cache entry: %v", err)
+	}
+	return entry
+}
+
+type fakeAuditor struct {
+	records []audit.Record
+}
+
+func (f *fakeAuditor) Log(rec audit.Record) error {
+	f.records = append(f.records, rec)
+	return nil
+}
+
+func TestProvider_Audit(t *testing.T) {
+	exp := time.Now().Add(1 * time.Hour)
+	auditor := &fakeAuditor{}
+	provider := &Provider{
+		SessionProvider: &fakeStsSessionProvider{
+			creds:     newStsCreds("ASIAFRESHKEY1234", "SECRET", "TOKEN", exp),
+			operation: "GetSessionToken",
+		},
+		CacheDir:     t.TempDir(),
+		Profile:      "test-profile",
+		ExpiryWindow: 5 * time.Minute,
+		Item:         defaultItem(),
+		MfaSerial:    "mfa-serial",
+		Audit:        auditor,
+		Caller:       audit.Caller{PID: 42, Command: "aws s3 ls"},
+	}
+
+	for range 2 {
+		if _, err := provider.Retrieve(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if len(auditor.records) != 2 {
+		t.Fatalf("len(records) = %d, want 2", len(auditor.records))
+	}
+	miss, hit := auditor.records[0], auditor.records[1]
+	if miss.CacheHit || !hit.CacheHit {
+		t.Errorf("CacheHit = %v, %v, want false, true", miss.CacheHit, hit.CacheHit)
+	}
+	if miss.Operation != "GetSessionToken" {
+		t.Errorf("Operation = %q, want %q", miss.Operation, "GetSessionToken")
+	}
+	if miss.AccessKeyIDPrefix != "ASIAFRES..." {
+		t.Errorf("AccessKeyIDPrefix = %q, want %q", miss.AccessKeyIDPrefix, "ASIAFRES...")
+	}
+	if miss.Vault != "vault-a" || miss.Item != "item-a" || miss.MfaSerial != "mfa-serial" {
+		t.Errorf("record = %+v, want vault-a/item-a/mfa-serial", miss)
+	}
+	if miss.CallerPID != 42 || miss.CallerCommand != "aws s3 ls" {
+		t.Errorf("caller = %d %q, want 42 %q", miss.CallerPID, miss.CallerCommand, "aws s3 ls")
+	}
+}
+
+func TestProvider_NoCacheFile(t *testing.T) {
+	cacheDir := t.TempDir()
+	exp := time.Now().Add(1 * time.Hour)
+	inner := &fakeStsSessionProvider{creds: newStsCreds("INNER_KEY", "INNER_SECRET", "INNER_TOKEN", exp)}
+
+	provider := &Provider{
+		SessionProvider: inner,
+		CacheDir:        cacheDir,
+		Profile:         "test-profile",
+		ExpiryWindow:    5 * time.Minute,
+		Item:            defaultItem(),
+		MfaSerial:       "mfa-serial",
+	}
+
+	got, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.AccessKeyID != "INNER_KEY" {
+		t.Errorf("AccessKeyID = %q, want %q", got.AccessKeyID, "INNER_KEY")
+	}
+	if inner.called != 1 {
+		t.Errorf("inner.called = %d, want 1", inner.called)
+	}
+	if _, err := os.Stat(provider.CachePath()); err != nil {
+		t.Fatalf("cache file was not created: %v", err)
+	}
+	entry := readCachedEntry(t, provider.CachePath())
+	if entry.Vault != provider.Item.Vault {
+		t.Errorf("entry.Vault = %q, want %q", entry.Vault, provider.Item.Vault)
+	}
+}
+
+func TestProvider_ValidCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	exp := time.Now().Add(1 * time.Hour)
+	inner := &fakeStsSessionProvider{creds: newStsCreds("INNER_KEY", "INNER_SECRET", "INNER_TOKEN", exp)}
+
+	provider := &Provider{
+		SessionProvider: inner,
+		CacheDir:        cacheDir,
+		Profile:         "test-profile",
+		ExpiryWindow:    5 * time.Minute,
+		Item:            defaultItem(),
+		MfaSerial:       "mfa-serial",
+	}
+
+	cached := cachedEntry{
+		Credentials:          newStsCreds("CACHED_KEY", "CACHED_SECRET", "CACHED_TOKEN", exp),
+		Vault:                provider.Item.Vault,
+		Item:                 provider.Item.Item,
+		MfaSerial:            provider.MfaSerial,
+		AccessKeyIDField:     provider.Item.AccessKeyIDField,
+		SecretAccessKeyField: provider.Item.SecretAccessKeyField,
+	}
+	if err := provider.writeCache(cached); err != nil {
+		t.Fatalf("failed to write cache: %v", err)
+	}
+
+	got, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.AccessKeyID != "CACHED_KEY" {
+		t.Errorf("AccessKeyID = %q, want %q", got.AccessKeyID, "CACHED_KEY")
+	}
+	if inner.called != 0 {
+		t.Errorf("inner.called = %d, want 0", inner.called)
+	}
+}
+
+func TestProvider_ParameterMismatchCausesCacheMiss(t *testing.T) {
+	keys := []string{"backend", "vault", "item", "tags", "sourceIdentity", "federationName", "policy", "mfa", "role", "accessKeyField", "secretKeyField"}
+	for _, key := range keys {
+		t.Run(key, func(t *testing.T) {
+			cacheDir := t.TempDir()
+			exp := time.Now().Add(1 * time.Hour)
+			inner := &fakeStsSessionProvider{creds: newStsCreds("FRESH_KEY", "FRESH_SECRET", "FRESH_TOKEN", exp)}
+
+			provider := &Provider{
+				SessionProvider: inner,
+				CacheDir:        cacheDir,
+				Profile:         "test-profile",
+				ExpiryWindow:    5 * time.Minute,
+				Item:            defaultItem(),
+				MfaSerial:       "mfa-serial",
+			}
+
+			cached := cachedEntry{
+				Credentials:          newStsCreds("CACHED_KEY", "CACHED_SECRET", "CACHED_TOKEN", exp),
+				Vault:                provider.Item.Vault,
+				Item:                 provider.Item.Item,
+				MfaSerial:            provider.MfaSerial,
+				AccessKeyIDField:     provider.Item.AccessKeyIDField,
+				SecretAccessKeyField: provider.Item.SecretAccessKeyField,
+			}
+
+			switch key {
+			case "backend":
+				cached.Backend = "bw"
+			case "vault":
+				cached.Vault = "different-vault"
+			case "tags":
+				cached.Tags = map[string]string{"team": "platform"}
+			case "sourceIdentity":
+				cached.SourceIdentity = "alice"
+			case "federationName":
+				cached.FederationName = "alice"
+			case "policy":
+				cached.PolicyDigest = (&Provider{PolicyArns: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}}).policyDigest()
+			case "item":
+				cached.Item = "different-item"
+			case "mfa":
+				cached.MfaSerial = "different-mfa"
+			case "role":
+				cached.RoleArn = "different-role"
+			case "accessKeyField":
+				cached.AccessKeyIDField = "different-access-key-field"
+			case "secretKeyField":
+				cached.SecretAccessKeyField = "different-secret-key-field"
+			}
+
+			if err := provider.writeCache(cached); err != nil {
+				t.Fatalf("failed to write cache: %v", err)
+			}
+
+			got, err := provider.Retrieve(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.AccessKeyID != "FRESH_KEY" {
+				t.Errorf("AccessKeyID = %q, want %q", got.AccessKeyID, "FRESH_KEY")
+			}
+			if inner.called != 1 {
+				t.Errorf("inner.called = %d, want 1", inner.called)
+			}
+		})
+	}
+}
+
+func TestProvider_CachePathSessionDigest(t *testing.T) {
+	untagged := &Provider{CacheDir: "/cache", Profile: "dev"}
+	if got, want := untagged.CachePath(), filepath.Join("/cache", "op-aws-credential-process", "dev.json"); got != want {
+		t.Errorf("CachePath = %q, want %q", got, want)
+	}
+
+	tagged := &Provider{CacheDir: "/cache", Profile: "dev", Tags: map[string]string{"team": "a", "env": "b"}, TransitiveTagKeys: []string{"team", "env"}}
+	reordered := &Provider{CacheDir: "/cache", Profile: "dev", Tags: map[string]string{"env": "b", "team": "a"}, TransitiveTagKeys: []string{"env", "team"}}
+	retagged := &Provider{CacheDir: "/cache", Profile: "dev", Tags: map[string]string{"team": "c", "env": "b"}, TransitiveTagKeys: []string{"team", "env"}}
+	withIdentity := &Provider{CacheDir: "/cache", Profile: "dev", SourceIdentity: "alice"}
+
+	if tagged.CachePath() == untagged.CachePath() {
+		t.Error("tagged session shares the untagged cache path")
+	}
+	if tagged.CachePath() != reordered.CachePath() {
+		t.Errorf("CachePath depends on tag order: %q != %q", tagged.CachePath(), reordered.CachePath())
+	}
+	if tagged.CachePath() == retagged.CachePath() {
+		t.Error("sessions with different tag values share a cache path")
+	}
+	if withIdentity.CachePath() == untagged.CachePath() {
+		t.Error("session with a source identity shares the untagged cache path")
+	}
+}
+
+func TestProvider_PolicyDigest(t *testing.T) {
+	const policy = `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "*"}]}`
+	unscoped := &Provider{CacheDir: "/cache", Profile: "dev"}
+	scoped := &Provider{CacheDir: "/cache", Profile: "dev", Policy: policy, PolicyArns: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess", "arn:aws:iam::aws:policy/AWSBillingReadOnlyAccess"}}
+	reformatted := &Provider{CacheDir: "/cache", Profile: "dev", Policy: "{\n  \"Version\": \"2012-10-17\",\n  \"Statement\": [{\"Effect\": \"Allow\", \"Action\": \"s3:GetObject\", \"Resource\": \"*\"}]\n}", PolicyArns: []string{"arn:aws:iam::aws:policy/AWSBillingReadOnlyAccess", "arn:aws:iam::aws:policy/ReadOnlyAccess"}}
+	arnOnly := &Provider{CacheDir: "/cache", Profile: "dev", PolicyArns: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}}
+
+	if unscoped.policyDigest() != "" {
+		t.Errorf("policyDigest = %q without policies, want empty", unscoped.policyDigest())
+	}
+	if scoped.CachePath() == unscoped.CachePath() {
+		t.Error("scoped session shares the unscoped cache path")
+	}
+	if scoped.policyDigest() != reformatted.policyDigest() {
+		t.Error("policyDigest depends on the policy's formatting or the order of the ARNs")
+	}
+	if scoped.policyDigest() == arnOnly.policyDigest() {
+		t.Error("sessions with different policies share a digest")
+	}
+}
+
+func TestProvider_ExpiredCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	inner := &fakeStsSessionProvider{creds: newStsCreds("FRESH_KEY", "FRESH_SECRET", "FRESH_TOKEN", time.Now().Add(1*time.Hour))}
+	provider := &Provider{
+		SessionProvider: inner,
+		CacheDir:        cacheDir,
+		Profile:         "test-profile",
+		ExpiryWindow:    5 * time.Minute,
+		Item:            defaultItem(),
+		MfaSerial:       "mfa-serial",
+	}
+
+	expired := cachedEntry{
+		Credentials:          newStsCreds("OLD_KEY", "OLD_SECRET", "OLD_TOKEN", time.Now().Add(2*time.Minute)),
+		Vault:                provider.Item.Vault,
+		Item:                 provider.Item.Item,
+		MfaSerial:            provider.MfaSerial,
+		AccessKeyIDField:     provider.Item.AccessKeyIDField,
+		SecretAccessKeyField: provider.Item.SecretAccessKeyField,
+	}
+	if err := provider.writeCache(expired); err != nil {
+		t.Fatalf("failed to write cache: %v", err)
+	}
+
+	got, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.AccessKeyID != "FRESH_KEY" {
+		t.Errorf("AccessKeyID = %q, want %q", got.AccessKeyID, "FRESH_KEY")
+	}
+	if inner.called != 1 {
+		t.Errorf("inner.called = %d, want 1", inner.called)
+	}
+}
+
+func TestProvider_CorruptedCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	exp := time.Now().Add(1 * time.Hour)
+	inner := &fakeStsSessionProvider{creds: newStsCreds("FRESH_KEY", "FRESH_SECRET", "FRESH_TOKEN", exp)}
+	provider := &Provider{
+		SessionProvider: inner,
+		CacheDir:        cacheDir,
+		Profile:         "test-profile",
+		ExpiryWindow:    5 * time.Minute,
+		Item:            defaultItem(),
+		MfaSerial:       "mfa-serial",
+	}
+
+	if err := os.MkdirAll(filepath.Dir(provider.CachePath()), 0700); err != nil {
+		t.Fatalf("failed to create cache dir: %v", err)
+	}
+	if err := os.WriteFile(provider.CachePath(), []byte("invalid json"), 0600); err != nil {
+		t.Fatalf("failed to write corrupted cache: %v", err)
+	}
+
+	got, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.AccessKeyID != "FRESH_KEY" {
+		t.Errorf("AccessKeyID = %q, want %q", got.AccessKeyID, "FRESH_KEY")
+	}
+	if inner.called != 1 {
+		t.Errorf("inner.called = %d, want 1", inner.called)
+	}
+}
+
+func TestProvider_InnerError(t *testing.T) {
+	provider := &Provider{
+		SessionProvider: &fakeStsSessionProvider{err: errors.New("inner error")},
+		CacheDir:        t.TempDir(),
+		Profile:         "test-profile",
+		ExpiryWindow:    5 * time.Minute,
+		Item:            defaultItem(),
+		MfaSerial:       "mfa-serial",
+	}
+
+	_, err := provider.Retrieve(context.Background())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if err.Error() != "inner error" {
+		t.Errorf("error = %q, want %q", err.Error(), "inner error")
+	}
+	if _, statErr := os.Stat(provider.CachePath()); !os.IsNotExist(statErr) {
+		t.Fatalf("cache should not be written on error, statErr=%v", statErr)
+	}
+}
+
+func TestProvider_CacheWriteFailureIsNonFatal(t *testing.T) {
+	cacheDir := t.TempDir()
+	blockingPath := filepath.Join(cacheDir, "op-aws-credential-process")
+	if err := os.WriteFile(blockingPath, []byte("not-a-directory"), 0600); err != nil {
+		t.Fatalf("failed to create blocking file: %v", err)
+	}
+
+	inner := &fakeStsSessionProvider{creds: newStsCreds("FRESH_KEY", "FRESH_SECRET", "FRESH_TOKEN", time.Now().Add(1*time.Hour))}
+	provider := &Provider{
+		SessionProvider: inner,
+		CacheDir:        cacheDir,
+		Profile:         "test-profile",
+		ExpiryWindow:    5 * time.Minute,
+		Item:            defaultItem(),
+		MfaSerial:       "mfa-serial",
+	}
+
+	got, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.AccessKeyID != "FRESH_KEY" {
+		t.Errorf("AccessKeyID = %q, want %q", got.AccessKeyID, "FRESH_KEY")
+	}
+	if inner.called != 1 {
+		t.Errorf("inner.called = %d, want 1", inner.called)
+	}
+}
+
+func TestProvider_CacheDirectoryCreated(t *testing.T) {
+	cacheDir := t.TempDir()
+	provider := &Provider{
+		SessionProvider: &fakeStsSessionProvider{creds: newStsCreds("KEY", "SECRET", "TOKEN", time.Now().Add(1*time.Hour))},
+		CacheDir:        cacheDir,
+		Profile:         "test-profile",
+		ExpiryWindow:    5 * time.Minute,
+		Item:            defaultItem(),
+		MfaSerial:       "mfa-serial",
+	}
+
+	if _, err := provider.Retrieve(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dir := filepath.Join(cacheDir, "op-aws-credential-process")
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("cache directory was not created: %v", err)
+	}
+	if !info.IsDir() {
+		t.Fatalf("cache path is not a directory")
+	}
+}
+
+func TestProvider_CachePath(t *testing.T) {
+	provider := &Provider{CacheDir: "/tmp/cache", Profile: "dev"}
+	if got := provider.CachePath(); got != "/tmp/cache/op-aws-credential-process/dev.json" {
+		t.Errorf("CachePath = %q, want %q", got, "/tmp/cache/op-aws-credential-process/dev.json")
+	}
+}
+
+func TestProvider_RetrieveStsCredentialsCacheHit(t *testing.T) {
+	cacheDir := t.TempDir()
+	exp := time.Now().Add(1 * time.Hour)
+	inner := &fakeStsSessionProvider{creds: newStsCreds("INNER_KEY", "INNER_SECRET", "INNER_TOKEN", exp)}
+	provider := &Provider{
+		SessionProvider: inner,
+		CacheDir:        cacheDir,
+		Profile:         "test-profile",
+		ExpiryWindow:    5 * time.Minute,
+		Item:            defaultItem(),
+		MfaSerial:       "mfa-serial",
+	}
+	if err := provider.writeCache(cachedEntry{
+		Credentials:          newStsCreds("CACHED_KEY", "CACHED_SECRET", "CACHED_TOKEN", exp),
+		Vault:                provider.Item.Vault,
+		Item:                 provider.Item.Item,
+		MfaSerial:            provider.MfaSerial,
+		AccessKeyIDField:     provider.Item.AccessKeyIDField,
+		SecretAccessKeyField: provider.Item.SecretAccessKeyField,
+	}); err != nil {
+		t.Fatalf("failed to write cache: %v", err)
+	}
+
+	creds, err := provider.RetrieveStsCredentials(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := aws.ToString(creds.AccessKeyId); got != "CACHED_KEY" {
+		t.Errorf("AccessKeyId = %q, want %q", got, "CACHED_KEY")
+	}
+	if inner.called != 0 {
+		t.Errorf("inner.called = %d, want 0", inner.called)
+	}
+}
+
+func TestProvider_RetrieveStsCredentialsCacheMiss(t *testing.T) {
+	cacheDir := t.TempDir()
+	exp := time.Now().Add(1 * time.Hour)
+	inner := &fakeStsSessionProvider{creds: newStsCreds("FRESH_KEY", "FRESH_SECRET", "FRESH_TOKEN", exp)}
+	provider := &Provider{
+		SessionProvider: inner,
+		CacheDir:        cacheDir,
+		Profile:         "test-profile",
+		ExpiryWindow:    5 * time.Minute,
+		Item:            defaultItem(),
+		MfaSerial:       "mfa-serial",
+	}
+
+	creds, err := provider.RetrieveStsCredentials(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := aws.ToString(creds.AccessKeyId); got != "FRESH_KEY" {
+		t.Errorf("AccessKeyId = %q, want %q", got, "FRESH_KEY")
+	}
+	if inner.called != 1 {
+		t.Errorf("inner.called = %d, want 1", inner.called)
+	}
+}
+
+func TestProvider_BackgroundRefresh(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		expiration time.Time
+		window     time.Duration
+		wantCalled int
+	}{
+		{"within window", now.Add(20 * time.Minute), 30 * time.Minute, 1},
+		{"outside window", now.Add(1 * time.Hour), 30 * time.Minute, 0},
+		{"disabled", now.Add(20 * time.Minute), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &fakeStsSessionProvider{creds: newStsCreds("FRESH_KEY", "FRESH_SECRET", "FRESH_TOKEN", now.Add(12*time.Hour))}
+			called := 0
+			provider := &Provider{
+				SessionProvider: inner,
+				CacheDir:        t.TempDir(),
+				Profile:         "test-profile",
+				ExpiryWindow:    5 * time.Minute,
+				Item:            defaultItem(),
+				MfaSerial:       "mfa-serial",
+				Now:             func() time.Time { return now },
+				RefreshWindow:   tt.window,
+				BackgroundRefresh: func() error {
+					called++
+					return nil
+				},
+			}
+			if err := provider.writeCache(cachedEntry{
+				Credentials:          newStsCreds("CACHED_KEY", "CACHED_SECRET", "CACHED_TOKEN", tt.expiration),
+				Vault:                provider.Item.Vault,
+				Item:                 provider.Item.Item,
+				MfaSerial:            provider.MfaSerial,
+				AccessKeyIDField:     provider.Item.AccessKeyIDField,
+				SecretAccessKeyField: provider.Item.SecretAccessKeyField,
+			}); err != nil {
+				t.Fatalf("failed to write cache: %v", err)
+			}
+
+			got, err := provider.Retrieve(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.AccessKeyID != "CACHED_KEY" {
+				t.Errorf("AccessKeyID = %q, want %q", got.AccessKeyID, "CACHED_KEY")
+			}
+			if called != tt.wantCalled {
+				t.Errorf("BackgroundRefresh called = %d, want %d", called, tt.wantCalled)
+			}
+			if inner.called != 0 {
+				t.Errorf("inner.called = %d, want 0", inner.called)
+			}
+		})
+	}
+}
+
+func TestProvider_RenewBypassesCache(t *testing.T) {
+	exp := time.Now().Add(1 * time.Hour)
+	inner := &fakeStsSessionProvider{creds: newStsCreds("FRESH_KEY", "FRESH_SECRET", "FRESH_TOKEN", exp)}
+	provider := &Provider{
+		SessionProvider: inner,
+		CacheDir:        t.TempDir(),
+		Profile:         "test-profile",
+		ExpiryWindow:    5 * time.Minute,
+		Item:            defaultItem(),
+		MfaSerial:       "mfa-serial",
+	}
+	if err := provider.writeCache(cachedEntry{
+		Credentials:          newStsCreds("CACHED_KEY", "CACHED_SECRET", "CACHED_TOKEN", exp),
+		Vault:                provider.Item.Vault,
+		Item:                 provider.Item.Item,
+		MfaSerial:            provider.MfaSerial,
+		AccessKeyIDField:     provider.Item.AccessKeyIDField,
+		SecretAccessKeyField: provider.Item.SecretAccessKeyField,
+	}); err != nil {
+		t.Fatalf("failed to write cache: %v", err)
+	}
+
+	creds, err := provider.Renew(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := aws.ToString(creds.AccessKeyId); got != "FRESH_KEY" {
+		t.Errorf("AccessKeyId = %q, want %q", got, "FRESH_KEY")
+	}
+	if entry := readCachedEntry(t, provider.CachePath()); aws.ToString(entry.Credentials.AccessKeyId) != "FRESH_KEY" {
+		t.Errorf("cached AccessKeyId = %q, want %q", aws.ToString(entry.Credentials.AccessKeyId), "FRESH_KEY")
+	}
+}
+
+func TestProvider_RenewIsAtomic(t *testing.T) {
+	inner := &fakeStsSessionProvider{creds: newStsCreds("FRESH_KEY", "FRESH_SECRET", "FRESH_TOKEN", time.Now().Add(1*time.Hour))}
+	provider := &Provider{
+		SessionProvider: inner,
+		CacheDir:        t.TempDir(),
+		Profile:         "test-profile",
+		ExpiryWindow:    5 * time.Minute,
+		Item:            defaultItem(),
+		MfaSerial:       "mfa-serial",
+	}
+	if _, err := provider.Renew(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 200 {
+			if _, err := provider.Renew(context.Background()); err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			info, err := os.Stat(provider.CachePath())
+			if err != nil {
+				t.Fatalf("failed to stat cache file: %v", err)
+			}
+			if mode := info.Mode().Perm(); mode != 0600 {
+				t.Errorf("cache file mode = %o, want 600", mode)
+			}
+			entries, _ := os.ReadDir(filepath.Dir(provider.CachePath()))
+			if len(entries) != 1 {
+				t.Errorf("cache directory has %d entries, want only the cache file", len(entries))
+			}
+			return
+		default:
+		}
+		if _, reason := provider.loadCache(); reason != "" {
+			t.Fatalf("cache read during Renew: %s", reason)
+		}
+	}
+}
+
+var _ aws.CredentialsProvider = (*Provider)(nil)
+var _ stssession.Provider = (*Provider)(nil)
@@ -0,0 +1,278 @@
+package stssession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/otp"
+)
+
+type AssumeRoleAPIClient interface {
+	AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
+}
+
+type GetRoleAPIClient interface {
+	GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error)
+}
+
+type AssumeRoleProvider struct {
+	BaseCredsProvider aws.CredentialsProvider
+	OTPSource         otp.Source
+	StsClient         AssumeRoleAPIClient
+	IamClient         GetRoleAPIClient
+	MfaDeviceClient   ListMFADevicesAPIClient
+	RoleArn           string
+	RoleSessionName   string
+	MfaSerial         string
+	MfaRetries        int
+	Duration          time.Duration
+
+	// Tags are passed as session tags, and those named in TransitiveTagKeys
+	// carry over to roles assumed from the session.
+	Tags              map[string]string
+	TransitiveTagKeys []string
+	// SourceIdentity is set on the session when not empty. Otherwise
+	// DefaultSourceIdentity, if set, is asked for one when the role is
+	// assumed.
+	SourceIdentity        string
+	DefaultSourceIdentity func(ctx context.Context) (string, error)
+	// resolvedSourceIdentity is the source identity of the last session.
+	resolvedSourceIdentity string
+
+	// Policy is an inline session policy in JSON, and PolicyArns are managed
+	// session policies. When set, the session is allowed only what both they
+	// and the role allow.
+	Policy     string
+	PolicyArns []string
+}
+
+// NewAssumeRoleProvider returns a provider assuming roleArn with the MFA
+// device mfaSerial and codes from source. optFns can change the session
+// name, the duration, the number of retries, the session tags, source
+// identity and policies, and the IAM client used to look up the role's
+// maximum session duration.
+func NewAssumeRoleProvider(client AssumeRoleAPIClient, base aws.CredentialsProvider, source otp.Source, mfaSerial, roleArn string, optFns ...func(*AssumeRoleProvider)) *AssumeRoleProvider {
+	p := &AssumeRoleProvider{
+		BaseCredsProvider: base,
+		OTPSource:         source,
+		StsClient:         client,
+		RoleArn:           roleArn,
+		RoleSessionName:   DefaultRoleSessionName,
+		MfaSerial:         mfaSerial,
+		MfaRetries:        DefaultMfaRetries,
+		Duration:          DefaultDuration,
+	}
+	for _, fn := range optFns {
+		fn(p)
+	}
+	return p
+}
+
+func (p *AssumeRoleProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
+	policy, err := sessionPolicy(p.Policy, p.PolicyArns)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMFADevice(ctx, p.MfaDeviceClient, p.MfaSerial); err != nil {
+		return nil, err
+	}
+
+	base, err := p.BaseCredsProvider.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// AWS caps role chaining sessions at one hour, and base credentials with
+	// a session token can only come from another role. Every role allows at
+	// least that, so its own maximum only matters otherwise.
+	duration, knownMax := p.Duration, false
+	if base.SessionToken != "" {
+		if err := validateDuration("AssumeRole (chained)", p.Duration, maxChainedRoleDuration); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := validateDuration("AssumeRole", p.Duration, maxAssumeRoleDuration); err != nil {
+			return nil, err
+		}
+		duration, knownMax = roleSessionDuration(ctx, p.IamClient, p.RoleArn, p.Duration)
+	}
+
+	sourceIdentity, err := p.sourceIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	assumeRole := func(ctx context.Context, code string) (*sts.AssumeRoleOutput, error) {
+		return p.assumeRole(ctx, code, duration, sourceIdentity, policy)
+	}
+	out, err := withMFARetry(ctx, p.OTPSource, p.MfaRetries, assumeRole)
+	if !knownMax && isDurationExceededError(err) {
+		if duration, err = exceededDurationRetry(ctx, p.RoleArn, duration, err); err != nil {
+			return nil, err
+		}
+		// The retry asks for a new code rather than sending the one STS
+		// has seen again.
+		out, err = withMFARetry(ctx, p.OTPSource, p.MfaRetries, assumeRole)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if out == nil || out.Credentials == nil {
+		return nil, errors.New("sts credentials were empty")
+	}
+	p.resolvedSourceIdentity = sourceIdentity
+
+	return out.Credentials, nil
+}
+
+// ResolvedSourceIdentity returns the source identity of the last session,
+// which is the one DefaultSourceIdentity gave when SourceIdentity is empty.
+func (p *AssumeRoleProvider) ResolvedSourceIdentity() string {
+	return p.resolvedSourceIdentity
+}
+
+func (p *AssumeRoleProvider) sourceIdentity(ctx context.Context) (string, error) {
+	if p.SourceIdentity != "" || p.DefaultSourceIdentity == nil {
+		return p.SourceIdentity, nil
+	}
+	return p.DefaultSourceIdentity(ctx)
+}
+
+func (p *AssumeRoleProvider) assumeRole(ctx context.Context, code string, duration time.Duration, sourceIdentity, policy string) (*sts.AssumeRoleOutput, error) {
+	slog.DebugContext(ctx, "calling sts:AssumeRole", "role_arn", p.RoleArn, "mfa_serial", p.MfaSerial, "duration", duration, "tags", len(p.Tags), "source_identity", sourceIdentity, "policy_arns", p.PolicyArns, "inline_policy", policy != "")
+	start := time.Now()
+	input := &sts.AssumeRoleInput{
+		RoleArn:           aws.String(p.RoleArn),
+		RoleSessionName:   aws.String(p.RoleSessionName),
+		DurationSeconds:   aws.Int32(int32(duration.Seconds())),
+		SerialNumber:      aws.String(p.MfaSerial),
+		TokenCode:         aws.String(code),
+		Tags:              sessionTags(p.Tags),
+		TransitiveTagKeys: p.TransitiveTagKeys,
+		PolicyArns:        policyDescriptors(p.PolicyArns),
+	}
+	if sourceIdentity != "" {
+		input.SourceIdentity = aws.String(sourceIdentity)
+	}
+	if policy != "" {
+		input.Policy = aws.String(policy)
+	}
+	out, err := p.StsClient.AssumeRole(ctx, input)
+	if err != nil {
+		slog.DebugContext(ctx, "sts:AssumeRole failed", "request_id", RequestID(err), "elapsed", time.Since(start), "error", err)
+		return nil, &Error{Operation: "AssumeRole", Err: err}
+	}
+	if out != nil && out.Credentials != nil {
+		logCredentials(ctx, "sts:AssumeRole", out.ResultMetadata, out.Credentials, time.Since(start))
+	}
+	return out, nil
+}
+
+// sessionTags sorts tags by key, so the same tags always make the same
+// request.
+func sessionTags(tags map[string]string) []ststypes.Tag {
+	var out []ststypes.Tag
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		out = append(out, ststypes.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return out
+}
+
+// roleSessionDuration caps duration at the MaxSessionDuration of the role
+// when client can read it, so that STS is not called with a duration it
+// rejects. It reports whether the role's maximum was known.
+func roleSessionDuration(ctx context.Context, client GetRoleAPIClient, roleArn string, duration time.Duration) (time.Duration, bool) {
+	maxDuration, ok := roleMaxSessionDuration(ctx, client, roleArn)
+	if !ok {
+		return duration, false
+	}
+	if duration > maxDuration {
+		slog.WarnContext(ctx, "requested duration exceeds the role's maximum session duration; using the maximum", "role_arn", roleArn, "duration", duration, "max_session_duration", maxDuration)
+		return maxDuration, true
+	}
+	return duration, true
+}
+
+// roleMaxSessionDuration reads the MaxSessionDuration of the role. It fails
+// without a client, or when the role cannot be read, e.g. because it lives in
+// another account.
+func roleMaxSessionDuration(ctx context.Context, client GetRoleAPIClient, roleArn string) (time.Duration, bool) {
+	if client == nil {
+		return 0, false
+	}
+
+	parsed, err := arn.Parse(roleArn)
+	if err != nil {
+		return 0, false
+	}
+	name := parsed.Resource[strings.LastIndex(parsed.Resource, "/")+1:]
+
+	out, err := client.GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(name)})
+	if err != nil || out.Role == nil || out.Role.MaxSessionDuration == nil {
+		slog.DebugContext(ctx, "role maximum session duration unknown", "role_arn", roleArn, "error", err)
+		return 0, false
+	}
+	return time.Duration(aws.ToInt32(out.Role.MaxSessionDuration)) * time.Second, true
+}
+
+func isDurationExceededError(err error) bool {
+	apiErr, ok := errors.AsType[smithy.APIError](err)
+	if !ok {
+		return false
+	}
+	return apiErr.ErrorCode() == "ValidationError" && strings.Contains(strings.ToLower(apiErr.ErrorMessage()), "durationseconds")
+}
+
+// durationLimitPattern finds the maximum in STS validation messages such as
+// "Member must have value less than or equal to 43200".
+var durationLimitPattern = regexp.MustCompile(`less than or equal to (\d+)`)
+
+// exceededDurationRetry returns the duration to retry with after STS
+// rejected duration, which it only knows when the error names the maximum.
+// The role's MaxSessionDuration could not be read, so guessing one could
+// issue a session shorter than the role allows; it fails instead.
+func exceededDurationRetry(ctx context.Context, roleArn string, duration time.Duration, err error) (time.Duration, error) {
+	apiErr, _ := errors.AsType[smithy.APIError](err)
+	if m := durationLimitPattern.FindStringSubmatch(apiErr.ErrorMessage()); m != nil {
+		if seconds, perr := strconv.Atoi(m[1]); perr == nil && time.Duration(seconds)*time.Second < duration {
+			maxDuration := time.Duration(seconds) * time.Second
+			slog.WarnContext(ctx, "requested duration exceeds the maximum STS reported; retrying with the maximum", "role_arn", roleArn, "duration", duration, "max_session_duration", maxDuration)
+			return maxDuration, nil
+		}
+	}
+	return 0, fmt.Errorf("duration %s exceeds the maximum session duration of %s, which could not be read; lower --duration: %w", duration, roleArn, err)
+}
+
+func (p *AssumeRoleProvider) Operation() string {
+	return "AssumeRole"
+}
+
+func (p *AssumeRoleProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.RetrieveStsCredentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+		CanExpire:       true,
+		Expires:         aws.ToTime(creds.Expiration),
+	}, nil
+}
@@ -0,0 +1,121 @@
+package stssession
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+type GetFederationTokenAPIClient interface {
+	GetFederationToken(ctx context.Context, params *sts.GetFederationTokenInput, optFns ...func(*sts.Options)) (*sts.GetFederationTokenOutput, error)
+}
+
+// FederationTokenProvider calls GetFederationToken with the base
+// credentials, for sessions scoped down by session policies, e.g. to hand to
+// a third-party tool. STS does not accept MFA for this call.
+type FederationTokenProvider struct {
+	BaseCredsProvider aws.CredentialsProvider
+	StsClient         GetFederationTokenAPIClient
+	Name              string
+	Duration          time.Duration
+
+	// Policy is an inline session policy in JSON, and PolicyArns are managed
+	// session policies. The session is granted what both they and the IAM
+	// user allow, so without any it has no permissions.
+	Policy     string
+	PolicyArns []string
+}
+
+// NewFederationTokenProvider returns a provider calling GetFederationToken
+// for the federated user name. optFns can change the duration and set the
+// session policies.
+func NewFederationTokenProvider(client GetFederationTokenAPIClient, base aws.CredentialsProvider, name string, optFns ...func(*FederationTokenProvider)) *FederationTokenProvider {
+	p := &FederationTokenProvider{
+		BaseCredsProvider: base,
+		StsClient:         client,
+		Name:              name,
+		Duration:          DefaultDuration,
+	}
+	for _, fn := range optFns {
+		fn(p)
+	}
+	return p
+}
+
+func (p *FederationTokenProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
+	policy, err := sessionPolicy(p.Policy, p.PolicyArns)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDuration("GetFederationToken", p.Duration, maxSessionTokenDuration); err != nil {
+		return nil, err
+	}
+
+	if _, err := p.BaseCredsProvider.Retrieve(ctx); err != nil {
+		return nil, err
+	}
+
+	out, err := p.getFederationToken(ctx, policy)
+	if err != nil {
+		return nil, err
+	}
+	if out == nil || out.Credentials == nil {
+		return nil, errors.New("sts credentials were empty")
+	}
+
+	return out.Credentials, nil
+}
+
+func (p *FederationTokenProvider) getFederationToken(ctx context.Context, policy string) (*sts.GetFederationTokenOutput, error) {
+	slog.DebugContext(ctx, "calling sts:GetFederationToken", "name", p.Name, "duration", p.Duration, "policy_arns", p.PolicyArns, "inline_policy", policy != "")
+	start := time.Now()
+	input := &sts.GetFederationTokenInput{
+		Name:            aws.String(p.Name),
+		DurationSeconds: aws.Int32(int32(p.Duration.Seconds())),
+		PolicyArns:      policyDescriptors(p.PolicyArns),
+	}
+	if policy != "" {
+		input.Policy = aws.String(policy)
+	}
+	out, err := p.StsClient.GetFederationToken(ctx, input)
+	if err != nil {
+		slog.DebugContext(ctx, "sts:GetFederationToken failed", "request_id", RequestID(err), "elapsed", time.Since(start), "error", err)
+		return nil, &Error{Operation: "GetFederationToken", Err: err}
+	}
+	if out != nil && out.Credentials != nil {
+		logCredentials(ctx, "sts:GetFederationToken", out.ResultMetadata, out.Credentials, time.Since(start))
+	}
+	return out, nil
+}
+
+func policyDescriptors(arns []string) []ststypes.PolicyDescriptorType {
+	var out []ststypes.PolicyDescriptorType
+	for _, arn := range arns {
+		out = append(out, ststypes.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+	return out
+}
+
+func (p *FederationTokenProvider) Operation() string {
+	return "GetFederationToken"
+}
+
+func (p *FederationTokenProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.RetrieveStsCredentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+		CanExpire:       true,
+		Expires:         aws.ToTime(creds.Expiration),
+	}, nil
+}
@@ -0,0 +1,82 @@
+package stssession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+var errNoMFASerial = errors.New("mfa_serial is not set; this tool requires an MFA device")
+
+// MFADeviceError reports an MFA device that cannot produce a token code.
+// STS only accepts codes from virtual or hardware TOTP devices, so a FIDO
+// security key can never satisfy GetSessionToken or AssumeRole.
+type MFADeviceError struct {
+	Serial string
+}
+
+func (e *MFADeviceError) Error() string {
+	return fmt.Sprintf("MFA device %s is a FIDO security key, which STS does not accept from the CLI.\n"+
+		"Register a virtual or hardware TOTP device for the IAM user as well, and select it with mfa_serial in the profile or --mfa-serial.\n"+
+		"Security keys keep working for console sign-in, and IAM Identity Center supports them for CLI access.", e.Serial)
+}
+
+type ListMFADevicesAPIClient interface {
+	ListMFADevices(ctx context.Context, params *iam.ListMFADevicesInput, optFns ...func(*iam.Options)) (*iam.ListMFADevicesOutput, error)
+}
+
+// isFIDOSerial reports whether serial is the ARN of a FIDO (U2F/WebAuthn)
+// device, arn:aws:iam::<account>:u2f/<path>. Hardware TOTP serials are not
+// ARNs at all.
+func isFIDOSerial(serial string) bool {
+	a, err := arn.Parse(serial)
+	if err != nil {
+		return false
+	}
+	return a.Service == "iam" && strings.HasPrefix(a.Resource, "u2f/")
+}
+
+// checkMFADevice fails early when no code-based MFA device can be used, so
+// the user is not prompted for a code that STS will never accept. Without a
+// serial, the user's devices are listed to tell a FIDO-only user apart from
+// a missing setting; client may be nil to skip that.
+func checkMFADevice(ctx context.Context, client ListMFADevicesAPIClient, serial string) error {
+	if isFIDOSerial(serial) {
+		return &MFADeviceError{Serial: serial}
+	}
+	if serial != "" {
+		return nil
+	}
+	if client == nil {
+		return errNoMFASerial
+	}
+
+	out, err := client.ListMFADevices(ctx, &iam.ListMFADevicesInput{})
+	if err != nil {
+		slog.DebugContext(ctx, "iam:ListMFADevices failed", "error", err)
+		return errNoMFASerial
+	}
+
+	var codeDevices, fidoDevices []string
+	for _, device := range out.MFADevices {
+		serial := aws.ToString(device.SerialNumber)
+		if isFIDOSerial(serial) {
+			fidoDevices = append(fidoDevices, serial)
+		} else {
+			codeDevices = append(codeDevices, serial)
+		}
+	}
+	switch {
+	case len(codeDevices) > 0:
+		return fmt.Errorf("%w; set mfa_serial in the profile or pass --mfa-serial (available: %s)", errNoMFASerial, strings.Join(codeDevices, ", "))
+	case len(fidoDevices) > 0:
+		return &MFADeviceError{Serial: fidoDevices[0]}
+	}
+	return errNoMFASerial
+}
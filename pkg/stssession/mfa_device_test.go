@@ -0,0 +1,120 @@
+package stssession
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+type fakeListMFADevicesClient struct {
+	serials []string
+	err     error
+	called  int
+}
+
+func (f *fakeListMFADevicesClient) ListMFADevices(ctx context.Context, params *iam.ListMFADevicesInput, optFns ...func(*iam.Options)) (*iam.ListMFADevicesOutput, error) {
+	f.called++
+	if f.err != nil {
+		return nil, f.err
+	}
+	out := &iam.ListMFADevicesOutput{}
+	for _, serial := range f.serials {
+		out.MFADevices = append(out.MFADevices, iamtypes.MFADevice{SerialNumber: aws.String(serial)})
+	}
+	return out, nil
+}
+
+func TestIsFIDOSerial(t *testing.T) {
+	tests := []struct {
+		serial string
+		want   bool
+	}{
+		{"arn:aws:iam::123456789012:u2f/user/alice/yubikey-ABCDEFGH", true},
+		{"arn:aws:iam::123456789012:mfa/alice", false},
+		{"GAHT12345678", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isFIDOSerial(tt.serial); got != tt.want {
+			t.Errorf("isFIDOSerial(%q) = %t, want %t", tt.serial, got, tt.want)
+		}
+	}
+}
+
+func TestCheckMFADevice(t *testing.T) {
+	const fido = "arn:aws:iam::123456789012:u2f/user/alice/yubikey-ABCDEFGH"
+	const virtual = "arn:aws:iam::123456789012:mfa/alice"
+
+	t.Run("fido serial", func(t *testing.T) {
+		client := &fakeListMFADevicesClient{}
+		err := checkMFADevice(context.Background(), client, fido)
+		if deviceErr, ok := errors.AsType[*MFADeviceError](err); !ok || deviceErr.Serial != fido {
+			t.Errorf("err = %v, want MFADeviceError for %s", err, fido)
+		}
+		if client.called != 0 {
+			t.Errorf("ListMFADevices called %d times, want 0", client.called)
+		}
+	})
+
+	t.Run("virtual serial", func(t *testing.T) {
+		client := &fakeListMFADevicesClient{}
+		if err := checkMFADevice(context.Background(), client, virtual); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if client.called != 0 {
+			t.Errorf("ListMFADevices called %d times, want 0", client.called)
+		}
+	})
+
+	t.Run("unset with only fido devices", func(t *testing.T) {
+		client := &fakeListMFADevicesClient{serials: []string{fido}}
+		err := checkMFADevice(context.Background(), client, "")
+		if _, ok := errors.AsType[*MFADeviceError](err); !ok {
+			t.Errorf("err = %v, want MFADeviceError", err)
+		}
+	})
+
+	t.Run("unset with a totp device", func(t *testing.T) {
+		client := &fakeListMFADevicesClient{serials: []string{fido, virtual}}
+		err := checkMFADevice(context.Background(), client, "")
+		if !errors.Is(err, errNoMFASerial) {
+			t.Fatalf("err = %v, want errNoMFASerial", err)
+		}
+		if !strings.Contains(err.Error(), virtual) {
+			t.Errorf("err = %v, want it to list %s", err, virtual)
+		}
+	})
+
+	t.Run("unset and listing fails", func(t *testing.T) {
+		client := &fakeListMFADevicesClient{err: errors.New("access denied")}
+		if err := checkMFADevice(context.Background(), client, ""); !errors.Is(err, errNoMFASerial) {
+			t.Errorf("err = %v, want errNoMFASerial", err)
+		}
+	})
+}
+
+func TestSessionTokenProvider_FIDOSerial(t *testing.T) {
+	otpSource := &fakeOTPSource{otp: "123456"}
+	stsClient := &fakeSTSClient{}
+	provider := &SessionTokenProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         otpSource,
+		StsClient:         stsClient,
+		MfaSerial:         "arn:aws:iam::123456789012:u2f/user/alice/yubikey-ABCDEFGH",
+		Duration:          12 * time.Hour,
+	}
+
+	_, err := provider.RetrieveStsCredentials(context.Background())
+	if _, ok := errors.AsType[*MFADeviceError](err); !ok {
+		t.Fatalf("err = %v, want MFADeviceError", err)
+	}
+	if otpSource.called != 0 {
+		t.Errorf("OTP requested %d times, want 0", otpSource.called)
+	}
+}
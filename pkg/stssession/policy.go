@@ -0,0 +1,115 @@
+package stssession
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+const (
+	// maxPolicyLength is what STS allows for the inline session policy and
+	// the managed policy ARNs together.
+	maxPolicyLength = 2048
+	maxPolicyArns   = 10
+)
+
+// PolicyError reports session policies that STS would reject, found before
+// calling it.
+type PolicyError struct {
+	Err error
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("invalid session policy: %v", e.Err)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// sessionPolicy checks the inline policy and the managed policy ARNs
+// locally and returns the inline policy compacted, which leaves more of the
+// size limit to the policy itself.
+func sessionPolicy(policy string, policyArns []string) (string, error) {
+	if len(policyArns) > maxPolicyArns {
+		return "", &PolicyError{Err: fmt.Errorf("%d managed policies given; at most %d are allowed", len(policyArns), maxPolicyArns)}
+	}
+	length := 0
+	for _, policyArn := range policyArns {
+		parsed, err := arn.Parse(policyArn)
+		if err != nil || parsed.Service != "iam" || !strings.HasPrefix(parsed.Resource, "policy/") {
+			return "", &PolicyError{Err: fmt.Errorf("%q is not a managed policy ARN", policyArn)}
+		}
+		length += len(policyArn)
+	}
+
+	if policy != "" {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, []byte(policy)); err != nil {
+			return "", &PolicyError{Err: err}
+		}
+		if err := validatePolicyDocument(compact.Bytes()); err != nil {
+			return "", &PolicyError{Err: err}
+		}
+		policy = compact.String()
+		length += len(policy)
+	}
+
+	if length > maxPolicyLength {
+		return "", &PolicyError{Err: fmt.Errorf("session policies are %d characters long; at most %d are allowed", length, maxPolicyLength)}
+	}
+	return policy, nil
+}
+
+type policyDocument struct {
+	Version   string          `json:"Version"`
+	Statement json.RawMessage `json:"Statement"`
+}
+
+type policyStatement struct {
+	Effect      string          `json:"Effect"`
+	Action      json.RawMessage `json:"Action"`
+	NotAction   json.RawMessage `json:"NotAction"`
+	Resource    json.RawMessage `json:"Resource"`
+	NotResource json.RawMessage `json:"NotResource"`
+}
+
+// validatePolicyDocument checks the elements every statement of a session
+// policy needs.
+func validatePolicyDocument(data []byte) error {
+	var doc policyDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if doc.Version != "2012-10-17" && doc.Version != "2008-10-17" {
+		return fmt.Errorf("policy version is %q; it must be 2012-10-17 or 2008-10-17", doc.Version)
+	}
+
+	var statements []policyStatement
+	if bytes.HasPrefix(doc.Statement, []byte("{")) {
+		var statement policyStatement
+		if err := json.Unmarshal(doc.Statement, &statement); err != nil {
+			return err
+		}
+		statements = []policyStatement{statement}
+	} else if err := json.Unmarshal(doc.Statement, &statements); err != nil || len(statements) == 0 {
+		return errors.New("policy statement must be a statement or a non-empty list of statements")
+	}
+
+	for i, statement := range statements {
+		if statement.Effect != "Allow" && statement.Effect != "Deny" {
+			return fmt.Errorf("statement %d: Effect is %q; it must be Allow or Deny", i+1, statement.Effect)
+		}
+		if statement.Action == nil && statement.NotAction == nil {
+			return fmt.Errorf("statement %d: Action or NotAction is required", i+1)
+		}
+		if statement.Resource == nil && statement.NotResource == nil {
+			return fmt.Errorf("statement %d: Resource or NotResource is required", i+1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package stssession
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/otp"
+)
+
+type GetSessionTokenAPIClient interface {
+	GetSessionToken(ctx context.Context, param *sts.GetSessionTokenInput, optFns ...func(*sts.Options)) (*sts.GetSessionTokenOutput, error)
+}
+
+type SessionTokenProvider struct {
+	BaseCredsProvider aws.CredentialsProvider
+	OTPSource         otp.Source
+	StsClient         GetSessionTokenAPIClient
+	MfaDeviceClient   ListMFADevicesAPIClient
+	MfaSerial         string
+	MfaRetries        int
+	Duration          time.Duration
+}
+
+// NewSessionTokenProvider returns a provider calling GetSessionToken with the
+// MFA device mfaSerial and codes from source. optFns can change the duration,
+// the number of retries and the client used to list MFA devices.
+func NewSessionTokenProvider(client GetSessionTokenAPIClient, base aws.CredentialsProvider, source otp.Source, mfaSerial string, optFns ...func(*SessionTokenProvider)) *SessionTokenProvider {
+	p := &SessionTokenProvider{
+		BaseCredsProvider: base,
+		OTPSource:         source,
+		StsClient:         client,
+		MfaSerial:         mfaSerial,
+		MfaRetries:        DefaultMfaRetries,
+		Duration:          DefaultDuration,
+	}
+	for _, fn := range optFns {
+		fn(p)
+	}
+	return p
+}
+
+func (p *SessionTokenProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
+	if err := checkMFADevice(ctx, p.MfaDeviceClient, p.MfaSerial); err != nil {
+		return nil, err
+	}
+	if err := validateDuration("GetSessionToken", p.Duration, maxSessionTokenDuration); err != nil {
+		return nil, err
+	}
+
+	if _, err := p.BaseCredsProvider.Retrieve(ctx); err != nil {
+		return nil, err
+	}
+
+	out, err := withMFARetry(ctx, p.OTPSource, p.MfaRetries, p.getSessionToken)
+	if err != nil {
+		return nil, err
+	}
+	if out == nil || out.Credentials == nil {
+		return nil, errors.New("sts credentials were empty")
+	}
+
+	return out.Credentials, nil
+}
+
+func (p *SessionTokenProvider) getSessionToken(ctx context.Context, code string) (*sts.GetSessionTokenOutput, error) {
+	slog.DebugContext(ctx, "calling sts:GetSessionToken", "mfa_serial", p.MfaSerial, "duration", p.Duration)
+	start := time.Now()
+	out, err := p.StsClient.GetSessionToken(ctx, &sts.GetSessionTokenInput{
+		DurationSeconds: aws.Int32(int32(p.Duration.Seconds())),
+		SerialNumber:    aws.String(p.MfaSerial),
+		TokenCode:       aws.String(code),
+	})
+	if err != nil {
+		slog.DebugContext(ctx, "sts:GetSessionToken failed", "request_id", RequestID(err), "elapsed", time.Since(start), "error", err)
+		return nil, &Error{Operation: "GetSessionToken", Err: err}
+	}
+	if out != nil && out.Credentials != nil {
+		logCredentials(ctx, "sts:GetSessionToken", out.ResultMetadata, out.Credentials, time.Since(start))
+	}
+	return out, nil
+}
+
+func (p *SessionTokenProvider) Operation() string {
+	return "GetSessionToken"
+}
+
+func (p *SessionTokenProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.RetrieveStsCredentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+		CanExpire:       true,
+		Expires:         aws.ToTime(creds.Expiration),
+	}, nil
+}
@@ -0,0 +1,134 @@
+// Package stssession exchanges long-term IAM user credentials and an MFA code,
+// or an OIDC token, for temporary STS credentials.
+package stssession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/otp"
+)
+
+const (
+	DefaultDuration        = 12 * time.Hour
+	DefaultMfaRetries      = 2
+	DefaultRoleSessionName = "op-aws-credential-process"
+)
+
+// Error reports a failed STS call.
+type Error struct {
+	Operation string
+	Err       error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("sts:%s: %v", e.Operation, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCode returns the AWS error code, e.g. AccessDenied, if any.
+func (e *Error) ErrorCode() string {
+	if apiErr, ok := errors.AsType[smithy.APIError](e.Err); ok {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+// OTPError reports a failure to get the MFA code from the otp.Source.
+type OTPError struct {
+	Err error
+}
+
+func (e *OTPError) Error() string {
+	return fmt.Sprintf("failed to get MFA code: %v", e.Err)
+}
+
+func (e *OTPError) Unwrap() error {
+	return e.Err
+}
+
+// RequestID returns the AWS request ID of a failed call, if any.
+func RequestID(err error) string {
+	if respErr, ok := errors.AsType[*awshttp.ResponseError](err); ok {
+		return respErr.ServiceRequestID()
+	}
+	return ""
+}
+
+func logCredentials(ctx context.Context, operation string, metadata middleware.Metadata, creds *ststypes.Credentials, elapsed time.Duration) {
+	requestID, _ := awsmiddleware.GetRequestIDMetadata(metadata)
+	slog.InfoContext(ctx, operation+" succeeded",
+		"request_id", requestID,
+		"access_key_id", aws.ToString(creds.AccessKeyId),
+		"expiration", aws.ToTime(creds.Expiration),
+		"elapsed", elapsed,
+	)
+}
+
+// withMFARetry asks for a new MFA code and calls again when STS rejects the
+// code, which happens on typos and when the code was already used.
+func withMFARetry[T any](ctx context.Context, source otp.Source, retries int, call func(ctx context.Context, code string) (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		code, err := source.OTP(ctx)
+		if err != nil {
+			var zero T
+			return zero, &OTPError{Err: err}
+		}
+
+		out, err := call(ctx, code)
+		if err == nil || attempt >= retries || !isMFAFailedError(err) {
+			return out, err
+		}
+		slog.WarnContext(ctx, "MFA code was rejected; retrying", "attempt", attempt+1, "retries", retries)
+
+		if ts, ok := source.(otp.TimeStepSource); ok {
+			if err := otp.WaitNextTimeStep(ctx, ts.TimeStep(), time.Now()); err != nil {
+				var zero T
+				return zero, err
+			}
+		}
+	}
+}
+
+func isMFAFailedError(err error) bool {
+	apiErr, ok := errors.AsType[smithy.APIError](err)
+	if !ok {
+		return false
+	}
+	return apiErr.ErrorCode() == "AccessDenied" && strings.Contains(apiErr.ErrorMessage(), "MultiFactorAuthentication failed")
+}
+
+const (
+	minSessionDuration      = 15 * time.Minute
+	maxSessionTokenDuration = 36 * time.Hour
+	maxAssumeRoleDuration   = 12 * time.Hour
+	maxChainedRoleDuration  = 1 * time.Hour
+)
+
+func validateDuration(operation string, duration, maxDuration time.Duration) error {
+	if duration < minSessionDuration || duration > maxDuration {
+		return fmt.Errorf("duration %s is out of range for %s; it must be between %s and %s", duration, operation, minSessionDuration, maxDuration)
+	}
+	return nil
+}
+
+// Provider is implemented by the providers in this package, which return the
+// STS credentials in full, including their expiration.
+type Provider interface {
+	aws.CredentialsProvider
+	RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error)
+}
@@ -0,0 +1,950 @@
+package stssession
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
+)
+
+type fakeOTPSource struct {
+	otp    string
+	err    error
+	called int
+}
+
+func (f *fakeOTPSource) OTP(ctx context.Context) (string, error) {
+	f.called++
+	return f.otp, f.err
+}
+
+type fakeCredsProvider struct {
+	creds  aws.Credentials
+	err    error
+	called int
+}
+
+func (f *fakeCredsProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	f.called++
+	return f.creds, f.err
+}
+
+type fakeTimeStepOTPSource struct {
+	fakeOTPSource
+	step  time.Duration
+	times []time.Time
+}
+
+func (f *fakeTimeStepOTPSource) OTP(ctx context.Context) (string, error) {
+	f.times = append(f.times, time.Now())
+	return f.fakeOTPSource.OTP(ctx)
+}
+
+func (f *fakeTimeStepOTPSource) TimeStep() time.Duration {
+	return f.step
+}
+
+type fakeSTSClient struct {
+	output    *sts.GetSessionTokenOutput
+	err       error
+	failFirst []error
+	calls     int
+	lastInput *sts.GetSessionTokenInput
+}
+
+func (f *fakeSTSClient) GetSessionToken(ctx context.Context, params *sts.GetSessionTokenInput, optFns ...func(*sts.Options)) (*sts.GetSessionTokenOutput, error) {
+	f.calls++
+	f.lastInput = params
+	if f.calls <= len(f.failFirst) {
+		return nil, f.failFirst[f.calls-1]
+	}
+	return f.output, f.err
+}
+
+func mfaFailedError() error {
+	return &smithy.GenericAPIError{
+		Code:    "AccessDenied",
+		Message: "MultiFactorAuthentication failed with invalid MFA one time pass code.",
+	}
+}
+
+type fakeAssumeRoleClient struct {
+	output    *sts.AssumeRoleOutput
+	err       error
+	failFirst []error
+	calls     int
+	lastInput *sts.AssumeRoleInput
+}
+
+func (f *fakeAssumeRoleClient) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	f.calls++
+	f.lastInput = params
+	if f.calls <= len(f.failFirst) {
+		return nil, f.failFirst[f.calls-1]
+	}
+	return f.output, f.err
+}
+
+type fakeWebIdentityClient struct {
+	output    *sts.AssumeRoleWithWebIdentityOutput
+	failFirst []error
+	calls     int
+	lastInput *sts.AssumeRoleWithWebIdentityInput
+}
+
+func (f *fakeWebIdentityClient) AssumeRoleWithWebIdentity(ctx context.Context, params *sts.AssumeRoleWithWebIdentityInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	f.calls++
+	f.lastInput = params
+	if f.calls <= len(f.failFirst) {
+		return nil, f.failFirst[f.calls-1]
+	}
+	return f.output, nil
+}
+
+type fakeFederationTokenClient struct {
+	output    *sts.GetFederationTokenOutput
+	err       error
+	lastInput *sts.GetFederationTokenInput
+}
+
+func (f *fakeFederationTokenClient) GetFederationToken(ctx context.Context, params *sts.GetFederationTokenInput, optFns ...func(*sts.Options)) (*sts.GetFederationTokenOutput, error) {
+	f.lastInput = params
+	return f.output, f.err
+}
+
+type fakeGetRoleClient struct {
+	maxSessionDuration int32
+	err                error
+	lastInput          *iam.GetRoleInput
+}
+
+func (f *fakeGetRoleClient) GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+	f.lastInput = params
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &iam.GetRoleOutput{Role: &iamtypes.Role{MaxSessionDuration: aws.Int32(f.maxSessionDuration)}}, nil
+}
+
+func newStsCreds(accessKey, secret, token string, expiration time.Time) *ststypes.Credentials {
+	return &ststypes.Credentials{
+		AccessKeyId:     aws.String(accessKey),
+		SecretAccessKey: aws.String(secret),
+		SessionToken:    aws.String(token),
+		Expiration:      aws.Time(expiration),
+	}
+}
+
+func TestSessionTokenProvider_Retrieve(t *testing.T) {
+	expiration := time.Now().Add(1 * time.Hour)
+	otpSource := &fakeOTPSource{otp: "123456"}
+	stsClient := &fakeSTSClient{
+		output: &sts.GetSessionTokenOutput{Credentials: newStsCreds("AKIA", "SECRET", "TOKEN", expiration)},
+	}
+
+	provider := &SessionTokenProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         otpSource,
+		StsClient:         stsClient,
+		MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+		Duration:          12 * time.Hour,
+	}
+
+	got, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.AccessKeyID != "AKIA" {
+		t.Errorf("AccessKeyID = %q, want %q", got.AccessKeyID, "AKIA")
+	}
+	if got.SecretAccessKey != "SECRET" {
+		t.Errorf("SecretAccessKey = %q, want %q", got.SecretAccessKey, "SECRET")
+	}
+	if got.SessionToken != "TOKEN" {
+		t.Errorf("SessionToken = %q, want %q", got.SessionToken, "TOKEN")
+	}
+	if !got.CanExpire {
+		t.Errorf("CanExpire = %v, want true", got.CanExpire)
+	}
+	if !got.Expires.Equal(expiration) {
+		t.Errorf("Expires = %v, want %v", got.Expires, expiration)
+	}
+	if stsClient.lastInput == nil {
+		t.Fatal("GetSessionToken was not called")
+	}
+	if got := aws.ToInt32(stsClient.lastInput.DurationSeconds); got != int32((12 * time.Hour).Seconds()) {
+		t.Errorf("DurationSeconds = %d, want %d", got, int32((12 * time.Hour).Seconds()))
+	}
+	if got := aws.ToString(stsClient.lastInput.SerialNumber); got != "arn:aws:iam::123456789012:mfa/user" {
+		t.Errorf("SerialNumber = %q, want %q", got, "arn:aws:iam::123456789012:mfa/user")
+	}
+}
+
+func TestSessionTokenProvider_OTPError(t *testing.T) {
+	provider := &SessionTokenProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         &fakeOTPSource{err: errors.New("failed to get OTP")},
+		StsClient:         &fakeSTSClient{},
+		MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+		Duration:          12 * time.Hour,
+	}
+
+	_, err := provider.Retrieve(context.Background())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if _, ok := errors.AsType[*OTPError](err); !ok {
+		t.Errorf("error = %T, want *OTPError", err)
+	}
+	if err.Error() != "failed to get MFA code: failed to get OTP" {
+		t.Errorf("error = %q, want %q", err.Error(), "failed to get MFA code: failed to get OTP")
+	}
+}
+
+func TestSessionTokenProvider_STSError(t *testing.T) {
+	provider := &SessionTokenProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         &fakeOTPSource{otp: "123456"},
+		StsClient:         &fakeSTSClient{err: errors.New("STS call failed")},
+		MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+		Duration:          12 * time.Hour,
+	}
+
+	_, err := provider.Retrieve(context.Background())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if stsErr, ok := errors.AsType[*Error](err); !ok || stsErr.Operation != "GetSessionToken" {
+		t.Errorf("error = %#v, want *STSError for GetSessionToken", err)
+	}
+	if err.Error() != "sts:GetSessionToken: STS call failed" {
+		t.Errorf("error = %q, want %q", err.Error(), "sts:GetSessionToken: STS call failed")
+	}
+}
+
+func TestSessionTokenProvider_RetrieveStsCredentials(t *testing.T) {
+	expiration := time.Now().Add(1 * time.Hour)
+	provider := &SessionTokenProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         &fakeOTPSource{otp: "123456"},
+		StsClient: &fakeSTSClient{
+			output: &sts.GetSessionTokenOutput{Credentials: newStsCreds("AKIA", "SECRET", "TOKEN", expiration)},
+		},
+		MfaSerial: "arn:aws:iam::123456789012:mfa/user",
+		Duration:  12 * time.Hour,
+	}
+
+	creds, err := provider.RetrieveStsCredentials(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := aws.ToString(creds.AccessKeyId); got != "AKIA" {
+		t.Errorf("AccessKeyId = %q, want %q", got, "AKIA")
+	}
+}
+
+func TestSessionTokenProvider_BaseCredsError(t *testing.T) {
+	baseCreds := &fakeCredsProvider{err: errors.New("base creds error")}
+	otpSource := &fakeOTPSource{otp: "123456"}
+	provider := &SessionTokenProvider{
+		BaseCredsProvider: baseCreds,
+		OTPSource:         otpSource,
+		StsClient:         &fakeSTSClient{},
+		MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+		Duration:          12 * time.Hour,
+	}
+
+	_, err := provider.RetrieveStsCredentials(context.Background())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if err.Error() != "base creds error" {
+		t.Errorf("error = %q, want %q", err.Error(), "base creds error")
+	}
+	if baseCreds.called != 1 {
+		t.Errorf("baseCreds.called = %d, want 1", baseCreds.called)
+	}
+	if otpSource.called != 0 {
+		t.Errorf("otpSource.called = %d, want 0", otpSource.called)
+	}
+}
+
+func TestSessionTokenProvider_MfaSerialEmpty(t *testing.T) {
+	baseCreds := &fakeCredsProvider{}
+	otpSource := &fakeOTPSource{otp: "123456"}
+	stsClient := &fakeSTSClient{}
+	provider := &SessionTokenProvider{
+		BaseCredsProvider: baseCreds,
+		OTPSource:         otpSource,
+		StsClient:         stsClient,
+		MfaSerial:         "",
+		Duration:          12 * time.Hour,
+	}
+
+	_, err := provider.RetrieveStsCredentials(context.Background())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if err.Error() != "mfa_serial is not set; this tool requires an MFA device" {
+		t.Errorf("error = %q, want %q", err.Error(), "mfa_serial is not set; this tool requires an MFA device")
+	}
+	if baseCreds.called != 0 {
+		t.Errorf("baseCreds.called = %d, want 0", baseCreds.called)
+	}
+	if otpSource.called != 0 {
+		t.Errorf("otpSource.called = %d, want 0", otpSource.called)
+	}
+	if stsClient.lastInput != nil {
+		t.Error("StsClient.GetSessionToken should not have been called")
+	}
+}
+
+func TestAssumeRoleProvider_Retrieve(t *testing.T) {
+	expiration := time.Now().Add(1 * time.Hour)
+	stsClient := &fakeAssumeRoleClient{
+		output: &sts.AssumeRoleOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", expiration)},
+	}
+
+	provider := &AssumeRoleProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         &fakeOTPSource{otp: "123456"},
+		StsClient:         stsClient,
+		RoleArn:           "arn:aws:iam::123456789012:role/admin",
+		RoleSessionName:   "session",
+		MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+		Duration:          1 * time.Hour,
+	}
+
+	got, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.AccessKeyID != "ASIA" {
+		t.Errorf("AccessKeyID = %q, want %q", got.AccessKeyID, "ASIA")
+	}
+	if !got.Expires.Equal(expiration) {
+		t.Errorf("Expires = %v, want %v", got.Expires, expiration)
+	}
+	if stsClient.lastInput == nil {
+		t.Fatal("AssumeRole was not called")
+	}
+	if got := aws.ToString(stsClient.lastInput.RoleArn); got != "arn:aws:iam::123456789012:role/admin" {
+		t.Errorf("RoleArn = %q, want %q", got, "arn:aws:iam::123456789012:role/admin")
+	}
+	if got := aws.ToString(stsClient.lastInput.RoleSessionName); got != "session" {
+		t.Errorf("RoleSessionName = %q, want %q", got, "session")
+	}
+	if got := aws.ToString(stsClient.lastInput.TokenCode); got != "123456" {
+		t.Errorf("TokenCode = %q, want %q", got, "123456")
+	}
+}
+
+func TestAssumeRoleProvider_SessionTagsAndSourceIdentity(t *testing.T) {
+	tests := []struct {
+		name                  string
+		sourceIdentity        string
+		defaultSourceIdentity func(ctx context.Context) (string, error)
+		want                  string
+	}{
+		{"none", "", nil, ""},
+		{"explicit", "alice", func(ctx context.Context) (string, error) { return "bob", nil }, "alice"},
+		{"default", "", func(ctx context.Context) (string, error) { return "bob", nil }, "bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stsClient := &fakeAssumeRoleClient{
+				output: &sts.AssumeRoleOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(time.Hour))},
+			}
+			provider := NewAssumeRoleProvider(stsClient, &fakeCredsProvider{}, &fakeOTPSource{otp: "123456"}, "arn:aws:iam::123456789012:mfa/user", "arn:aws:iam::123456789012:role/admin", func(p *AssumeRoleProvider) {
+				p.Duration = time.Hour
+				p.Tags = map[string]string{"team": "platform", "cost-center": "1234"}
+				p.TransitiveTagKeys = []string{"team"}
+				p.SourceIdentity = tt.sourceIdentity
+				p.DefaultSourceIdentity = tt.defaultSourceIdentity
+			})
+
+			if _, err := provider.RetrieveStsCredentials(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			input := stsClient.lastInput
+			var tags []string
+			for _, tag := range input.Tags {
+				tags = append(tags, aws.ToString(tag.Key)+"="+aws.ToString(tag.Value))
+			}
+			if want := []string{"cost-center=1234", "team=platform"}; !slices.Equal(tags, want) {
+				t.Errorf("Tags = %v, want %v", tags, want)
+			}
+			if !slices.Equal(input.TransitiveTagKeys, []string{"team"}) {
+				t.Errorf("TransitiveTagKeys = %v, want [team]", input.TransitiveTagKeys)
+			}
+			if got := aws.ToString(input.SourceIdentity); got != tt.want {
+				t.Errorf("SourceIdentity = %q, want %q", got, tt.want)
+			}
+			if tt.want == "" && input.SourceIdentity != nil {
+				t.Error("SourceIdentity was set, want nil")
+			}
+		})
+	}
+}
+
+func TestAssumeRoleProvider_MfaSerialEmpty(t *testing.T) {
+	stsClient := &fakeAssumeRoleClient{}
+	provider := &AssumeRoleProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         &fakeOTPSource{otp: "123456"},
+		StsClient:         stsClient,
+		RoleArn:           "arn:aws:iam::123456789012:role/admin",
+		Duration:          1 * time.Hour,
+	}
+
+	if _, err := provider.RetrieveStsCredentials(context.Background()); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if stsClient.lastInput != nil {
+		t.Error("StsClient.AssumeRole should not have been called")
+	}
+}
+
+func TestSessionTokenProvider_RetriesRejectedMFACode(t *testing.T) {
+	otpSource := &fakeOTPSource{otp: "123456"}
+	stsClient := &fakeSTSClient{
+		output:    &sts.GetSessionTokenOutput{Credentials: newStsCreds("AKIA", "SECRET", "TOKEN", time.Now().Add(1*time.Hour))},
+		failFirst: []error{mfaFailedError()},
+	}
+	provider := &SessionTokenProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         otpSource,
+		StsClient:         stsClient,
+		MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+		MfaRetries:        2,
+		Duration:          12 * time.Hour,
+	}
+
+	creds, err := provider.RetrieveStsCredentials(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := aws.ToString(creds.AccessKeyId); got != "AKIA" {
+		t.Errorf("AccessKeyId = %q, want %q", got, "AKIA")
+	}
+	if otpSource.called != 2 {
+		t.Errorf("otpSource.called = %d, want 2", otpSource.called)
+	}
+	if stsClient.calls != 2 {
+		t.Errorf("GetSessionToken calls = %d, want 2", stsClient.calls)
+	}
+}
+
+func TestSessionTokenProvider_MFARetriesExhausted(t *testing.T) {
+	otpSource := &fakeOTPSource{otp: "123456"}
+	stsClient := &fakeSTSClient{
+		failFirst: []error{mfaFailedError(), mfaFailedError(), mfaFailedError()},
+	}
+	provider := &SessionTokenProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         otpSource,
+		StsClient:         stsClient,
+		MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+		MfaRetries:        2,
+		Duration:          12 * time.Hour,
+	}
+
+	_, err := provider.RetrieveStsCredentials(context.Background())
+	if !isMFAFailedError(err) {
+		t.Fatalf("error = %v, want MFA failure", err)
+	}
+	if otpSource.called != 3 {
+		t.Errorf("otpSource.called = %d, want 3", otpSource.called)
+	}
+}
+
+func TestSessionTokenProvider_NoRetryOnOtherErrors(t *testing.T) {
+	otpSource := &fakeOTPSource{otp: "123456"}
+	stsClient := &fakeSTSClient{
+		err: &smithy.GenericAPIError{Code: "AccessDenied", Message: "User is not authorized to perform: sts:GetSessionToken"},
+	}
+	provider := &SessionTokenProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         otpSource,
+		StsClient:         stsClient,
+		MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+		MfaRetries:        2,
+		Duration:          12 * time.Hour,
+	}
+
+	if _, err := provider.RetrieveStsCredentials(context.Background()); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if otpSource.called != 1 {
+		t.Errorf("otpSource.called = %d, want 1", otpSource.called)
+	}
+}
+
+func TestSessionTokenProvider_RetryWaitsForNextTimeStep(t *testing.T) {
+	step := 20 * time.Millisecond
+	otpSource := &fakeTimeStepOTPSource{fakeOTPSource: fakeOTPSource{otp: "123456"}, step: step}
+	stsClient := &fakeSTSClient{
+		output:    &sts.GetSessionTokenOutput{Credentials: newStsCreds("AKIA", "SECRET", "TOKEN", time.Now().Add(1*time.Hour))},
+		failFirst: []error{mfaFailedError()},
+	}
+	provider := &SessionTokenProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         otpSource,
+		StsClient:         stsClient,
+		MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+		MfaRetries:        1,
+		Duration:          12 * time.Hour,
+	}
+
+	if _, err := provider.RetrieveStsCredentials(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(otpSource.times) != 2 {
+		t.Fatalf("OTP calls = %d, want 2", len(otpSource.times))
+	}
+	first := otpSource.times[0].UnixNano() / int64(step)
+	second := otpSource.times[1].UnixNano() / int64(step)
+	if second <= first {
+		t.Errorf("second OTP was requested in time step %d, want after %d", second, first)
+	}
+}
+
+func TestAssumeRoleProvider_RetriesRejectedMFACode(t *testing.T) {
+	otpSource := &fakeOTPSource{otp: "123456"}
+	stsClient := &fakeAssumeRoleClient{
+		output:    &sts.AssumeRoleOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(1*time.Hour))},
+		failFirst: []error{mfaFailedError()},
+	}
+	provider := &AssumeRoleProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         otpSource,
+		StsClient:         stsClient,
+		RoleArn:           "arn:aws:iam::123456789012:role/admin",
+		MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+		MfaRetries:        1,
+		Duration:          1 * time.Hour,
+	}
+
+	if _, err := provider.RetrieveStsCredentials(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if otpSource.called != 2 {
+		t.Errorf("otpSource.called = %d, want 2", otpSource.called)
+	}
+}
+
+func TestSessionTokenProvider_DurationOutOfRange(t *testing.T) {
+	for _, d := range []time.Duration{10 * time.Minute, 40 * time.Hour} {
+		otpSource := &fakeOTPSource{otp: "123456"}
+		stsClient := &fakeSTSClient{}
+		provider := &SessionTokenProvider{
+			BaseCredsProvider: &fakeCredsProvider{},
+			OTPSource:         otpSource,
+			StsClient:         stsClient,
+			MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+			Duration:          d,
+		}
+
+		if _, err := provider.RetrieveStsCredentials(context.Background()); err == nil {
+			t.Errorf("Duration %s: expected error, got nil", d)
+		}
+		if otpSource.called != 0 {
+			t.Errorf("Duration %s: otpSource.called = %d, want 0", d, otpSource.called)
+		}
+		if stsClient.lastInput != nil {
+			t.Errorf("Duration %s: StsClient.GetSessionToken should not have been called", d)
+		}
+	}
+}
+
+func TestAssumeRoleProvider_ChainedDurationOutOfRange(t *testing.T) {
+	stsClient := &fakeAssumeRoleClient{}
+	provider := &AssumeRoleProvider{
+		BaseCredsProvider: &fakeCredsProvider{creds: aws.Credentials{AccessKeyID: "ASIA", SecretAccessKey: "SECRET", SessionToken: "TOKEN"}},
+		OTPSource:         &fakeOTPSource{otp: "123456"},
+		StsClient:         stsClient,
+		RoleArn:           "arn:aws:iam::123456789012:role/admin",
+		MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+		Duration:          2 * time.Hour,
+	}
+
+	if _, err := provider.RetrieveStsCredentials(context.Background()); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if stsClient.calls != 0 {
+		t.Errorf("AssumeRole calls = %d, want 0", stsClient.calls)
+	}
+}
+
+func TestAssumeRoleProvider_RoleMaxSessionDuration(t *testing.T) {
+	durationErr := &smithy.GenericAPIError{
+		Code:    "ValidationError",
+		Message: "1 validation error detected: Value '43200' at 'durationSeconds' failed to satisfy constraint: Member must have value less than or equal to 7200",
+	}
+	tests := []struct {
+		name      string
+		iamClient *fakeGetRoleClient
+		failFirst []error
+		wantCalls int
+		want      time.Duration
+	}{
+		{name: "capped before the call", iamClient: &fakeGetRoleClient{maxSessionDuration: 4 * 3600}, wantCalls: 1, want: 4 * time.Hour},
+		{name: "within the role max", iamClient: &fakeGetRoleClient{maxSessionDuration: 12 * 3600}, wantCalls: 1, want: 12 * time.Hour},
+		{name: "get role fails", iamClient: &fakeGetRoleClient{err: errors.New("access denied")}, failFirst: []error{durationErr}, wantCalls: 2, want: 2 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stsClient := &fakeAssumeRoleClient{
+				output:    &sts.AssumeRoleOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(tt.want))},
+				failFirst: tt.failFirst,
+			}
+			source := &fakeOTPSource{otp: "123456"}
+			provider := &AssumeRoleProvider{
+				BaseCredsProvider: &fakeCredsProvider{},
+				OTPSource:         source,
+				StsClient:         stsClient,
+				IamClient:         tt.iamClient,
+				RoleArn:           "arn:aws:iam::123456789012:role/path/admin",
+				RoleSessionName:   "session",
+				MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+				Duration:          12 * time.Hour,
+			}
+
+			if _, err := provider.RetrieveStsCredentials(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if stsClient.calls != tt.wantCalls {
+				t.Errorf("AssumeRole calls = %d, want %d", stsClient.calls, tt.wantCalls)
+			}
+			if source.called != tt.wantCalls {
+				t.Errorf("OTP called %d times, want a new code for each of %d calls", source.called, tt.wantCalls)
+			}
+			if got := aws.ToInt32(stsClient.lastInput.DurationSeconds); got != int32(tt.want.Seconds()) {
+				t.Errorf("DurationSeconds = %d, want %d", got, int32(tt.want.Seconds()))
+			}
+			if got := aws.ToString(tt.iamClient.lastInput.RoleName); got != "admin" {
+				t.Errorf("RoleName = %q, want %q", got, "admin")
+			}
+		})
+	}
+}
+
+func TestAssumeRoleProvider_UnknownRoleMax(t *testing.T) {
+	stsClient := &fakeAssumeRoleClient{
+		err: &smithy.GenericAPIError{
+			Code:    "ValidationError",
+			Message: "The requested DurationSeconds exceeds the MaxSessionDuration set for this role.",
+		},
+	}
+	provider := &AssumeRoleProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         &fakeOTPSource{otp: "123456"},
+		StsClient:         stsClient,
+		IamClient:         &fakeGetRoleClient{err: errors.New("access denied")},
+		RoleArn:           "arn:aws:iam::123456789012:role/admin",
+		MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+		Duration:          12 * time.Hour,
+	}
+
+	_, err := provider.RetrieveStsCredentials(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "--duration") {
+		t.Errorf("err = %v, want an error naming --duration", err)
+	}
+	if stsClient.calls != 1 {
+		t.Errorf("AssumeRole calls = %d, want 1", stsClient.calls)
+	}
+}
+
+func TestAssumeRoleProvider_NoRetryWithKnownRoleMax(t *testing.T) {
+	stsClient := &fakeAssumeRoleClient{
+		err: &smithy.GenericAPIError{
+			Code:    "ValidationError",
+			Message: "The requested DurationSeconds exceeds the MaxSessionDuration set for this role.",
+		},
+	}
+	provider := &AssumeRoleProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         &fakeOTPSource{otp: "123456"},
+		StsClient:         stsClient,
+		IamClient:         &fakeGetRoleClient{maxSessionDuration: 4 * 3600},
+		RoleArn:           "arn:aws:iam::123456789012:role/admin",
+		MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+		Duration:          12 * time.Hour,
+	}
+
+	if _, err := provider.RetrieveStsCredentials(context.Background()); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if stsClient.calls != 1 {
+		t.Errorf("AssumeRole calls = %d, want 1", stsClient.calls)
+	}
+}
+
+func TestWebIdentityProvider_Retrieve(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("eyJ.token.sig\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	expiration := time.Now().Add(1 * time.Hour)
+	tests := []struct {
+		name   string
+		source TokenSource
+	}{
+		{"file", TokenFile(tokenFile)},
+		{"command", TokenCommand("echo eyJ.token.sig")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stsClient := &fakeWebIdentityClient{
+				output: &sts.AssumeRoleWithWebIdentityOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", expiration)},
+			}
+			provider := NewWebIdentityProvider(stsClient, tt.source, "arn:aws:iam::123456789012:role/ci", func(p *WebIdentityProvider) {
+				p.RoleSessionName = "session"
+				p.Duration = 1 * time.Hour
+			})
+
+			got, err := provider.Retrieve(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.AccessKeyID != "ASIA" {
+				t.Errorf("AccessKeyID = %q, want %q", got.AccessKeyID, "ASIA")
+			}
+			if got := aws.ToString(stsClient.lastInput.WebIdentityToken); got != "eyJ.token.sig" {
+				t.Errorf("WebIdentityToken = %q, want %q", got, "eyJ.token.sig")
+			}
+			if got := aws.ToString(stsClient.lastInput.RoleArn); got != "arn:aws:iam::123456789012:role/ci" {
+				t.Errorf("RoleArn = %q, want %q", got, "arn:aws:iam::123456789012:role/ci")
+			}
+			if got := aws.ToString(stsClient.lastInput.RoleSessionName); got != "session" {
+				t.Errorf("RoleSessionName = %q, want %q", got, "session")
+			}
+		})
+	}
+}
+
+func TestWebIdentityProvider_TokenError(t *testing.T) {
+	tests := []struct {
+		name   string
+		source TokenSource
+	}{
+		{"missing file", TokenFile(filepath.Join(t.TempDir(), "missing"))},
+		{"failing command", TokenCommand("exit 1")},
+		{"empty token", TokenCommand("true")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stsClient := &fakeWebIdentityClient{}
+			provider := NewWebIdentityProvider(stsClient, tt.source, "arn:aws:iam::123456789012:role/ci", func(p *WebIdentityProvider) {
+				p.Duration = 1 * time.Hour
+			})
+
+			_, err := provider.RetrieveStsCredentials(context.Background())
+			if _, ok := errors.AsType[*TokenError](err); !ok {
+				t.Errorf("err = %v, want *TokenError", err)
+			}
+			if stsClient.calls != 0 {
+				t.Errorf("AssumeRoleWithWebIdentity calls = %d, want 0", stsClient.calls)
+			}
+		})
+	}
+}
+
+func TestWebIdentityProvider_RetriesWithReportedMaxSessionDuration(t *testing.T) {
+	stsClient := &fakeWebIdentityClient{
+		output: &sts.AssumeRoleWithWebIdentityOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(1*time.Hour))},
+		failFirst: []error{&smithy.GenericAPIError{
+			Code:    "ValidationError",
+			Message: "1 validation error detected: Value '43200' at 'durationSeconds' failed to satisfy constraint: Member must have value less than or equal to 3600",
+		}},
+	}
+	provider := NewWebIdentityProvider(stsClient, TokenCommand("echo token"), "arn:aws:iam::123456789012:role/ci", func(p *WebIdentityProvider) {
+		p.Duration = 12 * time.Hour
+	})
+
+	if _, err := provider.RetrieveStsCredentials(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stsClient.calls != 2 {
+		t.Errorf("AssumeRoleWithWebIdentity calls = %d, want 2", stsClient.calls)
+	}
+	if got := aws.ToInt32(stsClient.lastInput.DurationSeconds); got != 3600 {
+		t.Errorf("DurationSeconds = %d, want 3600", got)
+	}
+}
+
+func TestWebIdentityProvider_UnknownRoleMax(t *testing.T) {
+	durationErr := &smithy.GenericAPIError{
+		Code:    "ValidationError",
+		Message: "The requested DurationSeconds exceeds the MaxSessionDuration set for this role.",
+	}
+	stsClient := &fakeWebIdentityClient{failFirst: []error{durationErr, durationErr}}
+	provider := NewWebIdentityProvider(stsClient, TokenCommand("echo token"), "arn:aws:iam::123456789012:role/ci")
+
+	_, err := provider.RetrieveStsCredentials(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "--duration") {
+		t.Errorf("err = %v, want an error naming --duration", err)
+	}
+	if stsClient.calls != 1 {
+		t.Errorf("AssumeRoleWithWebIdentity calls = %d, want 1", stsClient.calls)
+	}
+}
+
+func TestWebIdentityProvider_RoleMaxSessionDuration(t *testing.T) {
+	stsClient := &fakeWebIdentityClient{
+		output: &sts.AssumeRoleWithWebIdentityOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(2*time.Hour))},
+	}
+	provider := NewWebIdentityProvider(stsClient, TokenCommand("echo token"), "arn:aws:iam::123456789012:role/ci", func(p *WebIdentityProvider) {
+		p.IamClient = &fakeGetRoleClient{maxSessionDuration: 2 * 3600}
+	})
+
+	if _, err := provider.RetrieveStsCredentials(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stsClient.calls != 1 {
+		t.Errorf("AssumeRoleWithWebIdentity calls = %d, want 1", stsClient.calls)
+	}
+	if got := aws.ToInt32(stsClient.lastInput.DurationSeconds); got != 7200 {
+		t.Errorf("DurationSeconds = %d, want 7200", got)
+	}
+}
+
+func TestFederationTokenProvider_Retrieve(t *testing.T) {
+	const policy = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}]}`
+	base := &fakeCredsProvider{}
+	client := &fakeFederationTokenClient{
+		output: &sts.GetFederationTokenOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(time.Hour))},
+	}
+	provider := NewFederationTokenProvider(client, base, "alice", func(p *FederationTokenProvider) {
+		p.Duration = 1 * time.Hour
+		p.Policy = policy
+		p.PolicyArns = []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}
+	})
+
+	got, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.AccessKeyID != "ASIA" {
+		t.Errorf("AccessKeyID = %q, want %q", got.AccessKeyID, "ASIA")
+	}
+	if base.called != 1 {
+		t.Errorf("base credentials retrieved %d times, want 1", base.called)
+	}
+	if got := aws.ToString(client.lastInput.Name); got != "alice" {
+		t.Errorf("Name = %q, want %q", got, "alice")
+	}
+	if got := aws.ToInt32(client.lastInput.DurationSeconds); got != 3600 {
+		t.Errorf("DurationSeconds = %d, want 3600", got)
+	}
+	if got := aws.ToString(client.lastInput.Policy); got != policy {
+		t.Errorf("Policy = %q, want %q", got, policy)
+	}
+	if len(client.lastInput.PolicyArns) != 1 || aws.ToString(client.lastInput.PolicyArns[0].Arn) != "arn:aws:iam::aws:policy/ReadOnlyAccess" {
+		t.Errorf("PolicyArns = %v, want [arn:aws:iam::aws:policy/ReadOnlyAccess]", client.lastInput.PolicyArns)
+	}
+}
+
+func TestFederationTokenProvider_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     *fakeCredsProvider
+		client   *fakeFederationTokenClient
+		duration time.Duration
+		wantSTS  bool
+	}{
+		{"base credentials", &fakeCredsProvider{err: errors.New("locked")}, &fakeFederationTokenClient{}, time.Hour, false},
+		{"sts", &fakeCredsProvider{}, &fakeFederationTokenClient{err: errors.New("timeout")}, time.Hour, true},
+		{"duration", &fakeCredsProvider{}, &fakeFederationTokenClient{}, 37 * time.Hour, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewFederationTokenProvider(tt.client, tt.base, "alice", func(p *FederationTokenProvider) {
+				p.Duration = tt.duration
+			})
+			_, err := provider.RetrieveStsCredentials(context.Background())
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if _, ok := errors.AsType[*Error](err); ok != tt.wantSTS {
+				t.Errorf("err = %v, want *Error %v", err, tt.wantSTS)
+			}
+		})
+	}
+}
+
+func TestAssumeRoleProvider_SessionPolicies(t *testing.T) {
+	stsClient := &fakeAssumeRoleClient{
+		output: &sts.AssumeRoleOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(time.Hour))},
+	}
+	provider := NewAssumeRoleProvider(stsClient, &fakeCredsProvider{}, &fakeOTPSource{otp: "123456"}, "arn:aws:iam::123456789012:mfa/user", "arn:aws:iam::123456789012:role/admin", func(p *AssumeRoleProvider) {
+		p.Duration = 1 * time.Hour
+		p.Policy = `{
+  "Version": "2012-10-17",
+  "Statement": {"Effect": "Allow", "Action": "s3:Get*", "Resource": "*"}
+}`
+		p.PolicyArns = []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}
+	})
+
+	if _, err := provider.RetrieveStsCredentials(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `{"Version":"2012-10-17","Statement":{"Effect":"Allow","Action":"s3:Get*","Resource":"*"}}`
+	if got := aws.ToString(stsClient.lastInput.Policy); got != want {
+		t.Errorf("Policy = %q, want %q", got, want)
+	}
+	if len(stsClient.lastInput.PolicyArns) != 1 || aws.ToString(stsClient.lastInput.PolicyArns[0].Arn) != "arn:aws:iam::aws:policy/ReadOnlyAccess" {
+		t.Errorf("PolicyArns = %v, want [arn:aws:iam::aws:policy/ReadOnlyAccess]", stsClient.lastInput.PolicyArns)
+	}
+}
+
+func TestSessionPolicy_Invalid(t *testing.T) {
+	const statement = `{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}`
+	tests := []struct {
+		name       string
+		policy     string
+		policyArns []string
+	}{
+		{"not json", `{"Version":`, nil},
+		{"no version", `{"Statement":[` + statement + `]}`, nil},
+		{"unknown version", `{"Version":"2024-01-01","Statement":[` + statement + `]}`, nil},
+		{"no statement", `{"Version":"2012-10-17"}`, nil},
+		{"empty statement", `{"Version":"2012-10-17","Statement":[]}`, nil},
+		{"no effect", `{"Version":"2012-10-17","Statement":[{"Action":"s3:GetObject","Resource":"*"}]}`, nil},
+		{"bad effect", `{"Version":"2012-10-17","Statement":[{"Effect":"allow","Action":"s3:GetObject","Resource":"*"}]}`, nil},
+		{"no action", `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Resource":"*"}]}`, nil},
+		{"no resource", `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject"}]}`, nil},
+		{"too long", `{"Version":"2012-10-17","Statement":[` + strings.Repeat(statement+",", 40) + statement + `]}`, nil},
+		{"not a policy arn", "", []string{"arn:aws:iam::123456789012:role/admin"}},
+		{"too many arns", "", slices.Repeat([]string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}, 11)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stsClient := &fakeAssumeRoleClient{}
+			provider := NewAssumeRoleProvider(stsClient, &fakeCredsProvider{}, &fakeOTPSource{otp: "123456"}, "arn:aws:iam::123456789012:mfa/user", "arn:aws:iam::123456789012:role/admin", func(p *AssumeRoleProvider) {
+				p.Duration = 1 * time.Hour
+				p.Policy = tt.policy
+				p.PolicyArns = tt.policyArns
+			})
+
+			_, err := provider.RetrieveStsCredentials(context.Background())
+			if _, ok := errors.AsType[*PolicyError](err); !ok {
+				t.Errorf("err = %v, want *PolicyError", err)
+			}
+			if stsClient.calls != 0 {
+				t.Errorf("AssumeRole calls = %d, want 0", stsClient.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package stssession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+type AssumeRoleWithWebIdentityAPIClient interface {
+	AssumeRoleWithWebIdentity(ctx context.Context, params *sts.AssumeRoleWithWebIdentityInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error)
+}
+
+// TokenSource returns the OIDC token exchanged for role credentials.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenFile reads the token from a file, which CI runners and Kubernetes
+// rewrite before the token in it expires.
+type TokenFile string
+
+func (f TokenFile) Token(ctx context.Context) (string, error) {
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// TokenCommand runs a shell command and takes the token from its standard
+// output.
+type TokenCommand string
+
+func (c TokenCommand) Token(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "sh", "-c", string(c)).Output()
+	if exitErr, ok := errors.AsType[*exec.ExitError](err); ok && len(exitErr.Stderr) > 0 {
+		return "", fmt.Errorf("%w\n%s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// TokenError reports a failure to get the OIDC token from the TokenSource.
+type TokenError struct {
+	Err error
+}
+
+func (e *TokenError) Error() string {
+	return fmt.Sprintf("failed to get web identity token: %v", e.Err)
+}
+
+func (e *TokenError) Unwrap() error {
+	return e.Err
+}
+
+// WebIdentityProvider assumes a role with an OIDC token instead of IAM user
+// credentials, as CI runners do. The call is not signed, so it needs neither
+// long-term credentials nor MFA.
+type WebIdentityProvider struct {
+	TokenSource     TokenSource
+	StsClient       AssumeRoleWithWebIdentityAPIClient
+	RoleArn         string
+	RoleSessionName string
+	Duration        time.Duration
+	// IamClient, when set, reads the role's maximum session duration before
+	// the call. The call itself needs no credentials, so without one that
+	// maximum is only learnt from STS rejecting the duration.
+	IamClient GetRoleAPIClient
+
+	// Policy and PolicyArns are session policies, as for AssumeRoleProvider.
+	Policy     string
+	PolicyArns []string
+}
+
+// NewWebIdentityProvider returns a provider assuming roleArn with tokens
+// from source. optFns can change the session name, the duration, the
+// session policies and the IAM client reading the role.
+func NewWebIdentityProvider(client AssumeRoleWithWebIdentityAPIClient, source TokenSource, roleArn string, optFns ...func(*WebIdentityProvider)) *WebIdentityProvider {
+	p := &WebIdentityProvider{
+		TokenSource:     source,
+		StsClient:       client,
+		RoleArn:         roleArn,
+		RoleSessionName: DefaultRoleSessionName,
+		Duration:        DefaultDuration,
+	}
+	for _, fn := range optFns {
+		fn(p)
+	}
+	return p
+}
+
+func (p *WebIdentityProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
+	policy, err := sessionPolicy(p.Policy, p.PolicyArns)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDuration("AssumeRoleWithWebIdentity", p.Duration, maxAssumeRoleDuration); err != nil {
+		return nil, err
+	}
+
+	token, err := p.TokenSource.Token(ctx)
+	if err != nil {
+		return nil, &TokenError{Err: err}
+	}
+	if token == "" {
+		return nil, &TokenError{Err: errors.New("token is empty")}
+	}
+
+	duration, knownMax := roleSessionDuration(ctx, p.IamClient, p.RoleArn, p.Duration)
+	out, err := p.assumeRoleWithWebIdentity(ctx, token, duration, policy)
+	if !knownMax && isDurationExceededError(err) {
+		if duration, err = exceededDurationRetry(ctx, p.RoleArn, duration, err); err != nil {
+			return nil, err
+		}
+		out, err = p.assumeRoleWithWebIdentity(ctx, token, duration, policy)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if out == nil || out.Credentials == nil {
+		return nil, errors.New("sts credentials were empty")
+	}
+
+	return out.Credentials, nil
+}
+
+func (p *WebIdentityProvider) assumeRoleWithWebIdentity(ctx context.Context, token string, duration time.Duration, policy string) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	slog.DebugContext(ctx, "calling sts:AssumeRoleWithWebIdentity", "role_arn", p.RoleArn, "duration", duration, "policy_arns", p.PolicyArns, "inline_policy", policy != "")
+	start := time.Now()
+	input := &sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.RoleArn),
+		RoleSessionName:  aws.String(p.RoleSessionName),
+		DurationSeconds:  aws.Int32(int32(duration.Seconds())),
+		WebIdentityToken: aws.String(token),
+		PolicyArns:       policyDescriptors(p.PolicyArns),
+	}
+	if policy != "" {
+		input.Policy = aws.String(policy)
+	}
+	out, err := p.StsClient.AssumeRoleWithWebIdentity(ctx, input)
+	if err != nil {
+		slog.DebugContext(ctx, "sts:AssumeRoleWithWebIdentity failed", "request_id", RequestID(err), "elapsed", time.Since(start), "error", err)
+		return nil, &Error{Operation: "AssumeRoleWithWebIdentity", Err: err}
+	}
+	if out != nil && out.Credentials != nil {
+		logCredentials(ctx, "sts:AssumeRoleWithWebIdentity", out.ResultMetadata, out.Credentials, time.Since(start))
+	}
+	return out, nil
+}
+
+func (p *WebIdentityProvider) Operation() string {
+	return "AssumeRoleWithWebIdentity"
+}
+
+func (p *WebIdentityProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.RetrieveStsCredentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+		CanExpire:       true,
+		Expires:         aws.ToTime(creds.Expiration),
+	}, nil
+}
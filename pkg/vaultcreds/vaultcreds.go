@@ -0,0 +1,196 @@
+// Package vaultcreds reads AWS access keys from a HashiCorp Vault KV version
+// 2 secrets engine.
+package vaultcreds
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+)
+
+const (
+	Name                        = "vault"
+	DefaultMount                = "secret"
+	DefaultAccessKeyIDField     = "access_key_id"
+	DefaultSecretAccessKeyField = "secret_access_key"
+)
+
+// DefaultTokenFile returns ~/.vault-token, where vault login stores the
+// token.
+func DefaultTokenFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".vault-token")
+}
+
+// CredentialSource is a backend.Backend returning the long-term access key
+// stored in a KV v2 secret. Item.Vault is the mount of the secrets engine
+// and Item.Item the path of the secret below it.
+type CredentialSource struct {
+	Address   string
+	Token     string
+	TokenFile string
+	// Namespace is sent as X-Vault-Namespace when not empty.
+	Namespace string
+	// CACert is a PEM file of the CA certificates to trust, for servers
+	// behind a private CA. It is ignored when HTTPClient is set.
+	CACert     string
+	HTTPClient *http.Client
+	backend.Item
+}
+
+// NewCredentialSource returns a source for the secret at path in the KV
+// engine mounted at mount on the server at address. The token is taken from
+// VAULT_TOKEN, or read from DefaultTokenFile when that is not set, and the
+// namespace and CA certificates from VAULT_NAMESPACE and VAULT_CACERT, as the
+// vault CLI does.
+func NewCredentialSource(address, mount, path string, optFns ...func(*CredentialSource)) *CredentialSource {
+	s := &CredentialSource{
+		Address:   address,
+		Token:     os.Getenv("VAULT_TOKEN"),
+		TokenFile: DefaultTokenFile(),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+		CACert:    os.Getenv("VAULT_CACERT"),
+		Item: backend.Item{
+			Vault:                mount,
+			Item:                 path,
+			AccessKeyIDField:     DefaultAccessKeyIDField,
+			SecretAccessKeyField: DefaultSecretAccessKeyField,
+		},
+	}
+	for _, fn := range optFns {
+		fn(s)
+	}
+	return s
+}
+
+func (s *CredentialSource) command() string {
+	return "kv get " + strings.Trim(s.Vault, "/") + "/" + strings.Trim(s.Item.Item, "/")
+}
+
+func (s *CredentialSource) token() (string, error) {
+	if s.Token != "" {
+		return s.Token, nil
+	}
+	if s.TokenFile == "" {
+		return "", errors.New("no token; set VAULT_TOKEN or log in with vault login")
+	}
+	data, err := os.ReadFile(s.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("no token; set VAULT_TOKEN or log in with vault login: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s *CredentialSource) httpClient() (*http.Client, error) {
+	if s.HTTPClient != nil {
+		return s.HTTPClient, nil
+	}
+	if s.CACert == "" {
+		return http.DefaultClient, nil
+	}
+	pem, err := os.ReadFile(s.CACert)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", s.CACert)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
+
+type kvResponse struct {
+	Data struct {
+		Data map[string]any `json:"data"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+func (s *CredentialSource) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	data, err := s.read(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	accessKeyID, _ := data[s.AccessKeyIDField].(string)
+	secretAccessKey, _ := data[s.SecretAccessKeyField].(string)
+	if accessKeyID == "" || secretAccessKey == "" {
+		return aws.Credentials{}, &backend.Error{Backend: Name, Command: s.command(), Err: errors.New("missing credentials in vault secret")}
+	}
+	return aws.Credentials{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+	}, nil
+}
+
+func (s *CredentialSource) Name() string {
+	return Name
+}
+
+// read fetches the latest version of the secret from
+// /v1/<mount>/data/<path>.
+func (s *CredentialSource) read(ctx context.Context) (map[string]any, error) {
+	token, err := s.token()
+	if err != nil {
+		return nil, &backend.Error{Backend: Name, Command: s.command(), Err: err}
+	}
+	client, err := s.httpClient()
+	if err != nil {
+		return nil, &backend.Error{Backend: Name, Command: s.command(), Err: err}
+	}
+
+	endpoint, err := url.JoinPath(s.Address, "v1", strings.Trim(s.Vault, "/"), "data", strings.Trim(s.Item.Item, "/"))
+	if err != nil {
+		return nil, &backend.Error{Backend: Name, Command: s.command(), Err: err}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, &backend.Error{Backend: Name, Command: s.command(), Err: err}
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if s.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.Namespace)
+	}
+
+	slog.DebugContext(ctx, "reading vault secret", "mount", s.Vault, "path", s.Item.Item)
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.DebugContext(ctx, "vault read failed", "elapsed", time.Since(start), "error", err)
+		return nil, &backend.Error{Backend: Name, Command: s.command(), Err: err}
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var body kvResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK {
+		slog.DebugContext(ctx, "vault read failed", "elapsed", time.Since(start), "status", resp.StatusCode)
+		return nil, &backend.Error{Backend: Name, Command: s.command(), Err: errors.New(resp.Status), Stderr: strings.Join(body.Errors, "\n")}
+	}
+	if decodeErr != nil {
+		return nil, &backend.Error{Backend: Name, Command: s.command(), Err: decodeErr}
+	}
+	slog.InfoContext(ctx, "vault read succeeded", "mount", s.Vault, "path", s.Item.Item, "elapsed", time.Since(start))
+	return body.Data.Data, nil
+}
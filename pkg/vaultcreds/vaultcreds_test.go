@@ -0,0 +1,151 @@
+package vaultcreds
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+)
+
+var _ backend.Backend = (*CredentialSource)(nil)
+
+// newVault starts a stand-in for a Vault server holding one KV v2 secret at
+// secret/aws/dev, readable with token.
+func newVault(t *testing.T, token string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		if r.Method != http.MethodGet || r.URL.Path != "/v1/secret/data/aws/dev" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"data":{"access_key_id":"AKIAEXAMPLE","secret_access_key":"secret","id":"AKIAOTHER","key":"other"},"metadata":{"version":3}}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCredentialSource(t *testing.T) {
+	server := newVault(t, "s.token")
+	t.Setenv("VAULT_TOKEN", "s.token")
+	source := NewCredentialSource(server.URL, "secret", "aws/dev")
+
+	creds, err := source.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if creds.AccessKeyID != "AKIAEXAMPLE" || creds.SecretAccessKey != "secret" {
+		t.Errorf("creds = %+v, want AKIAEXAMPLE/secret", creds)
+	}
+}
+
+func TestCredentialSource_NamespaceAndCACert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Namespace") != "team-a" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":["no handler for route"]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"data":{"access_key_id":"AKIAEXAMPLE","secret_access_key":"secret"}}}`))
+	}))
+	t.Cleanup(server.Close)
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caCert, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VAULT_TOKEN", "s.token")
+	t.Setenv("VAULT_NAMESPACE", "team-a")
+	t.Setenv("VAULT_CACERT", caCert)
+
+	creds, err := NewCredentialSource(server.URL, "secret", "aws/dev").Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if creds.AccessKeyID != "AKIAEXAMPLE" {
+		t.Errorf("AccessKeyID = %q, want AKIAEXAMPLE", creds.AccessKeyID)
+	}
+
+	t.Setenv("VAULT_CACERT", "")
+	if _, err := NewCredentialSource(server.URL, "secret", "aws/dev").Retrieve(context.Background()); err == nil {
+		t.Error("expected an error for a server behind an untrusted CA, got nil")
+	}
+}
+
+func TestCredentialSource_TokenFileAndKeys(t *testing.T) {
+	server := newVault(t, "s.fromfile")
+	t.Setenv("VAULT_TOKEN", "")
+	tokenFile := filepath.Join(t.TempDir(), ".vault-token")
+	if err := os.WriteFile(tokenFile, []byte("s.fromfile\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	source := NewCredentialSource(server.URL, "secret", "aws/dev", func(s *CredentialSource) {
+		s.TokenFile = tokenFile
+		s.AccessKeyIDField = "id"
+		s.SecretAccessKeyField = "key"
+	})
+
+	creds, err := source.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if creds.AccessKeyID != "AKIAOTHER" || creds.SecretAccessKey != "other" {
+		t.Errorf("creds = %+v, want AKIAOTHER/other", creds)
+	}
+}
+
+func TestCredentialSource_Error(t *testing.T) {
+	server := newVault(t, "s.token")
+	tests := []struct {
+		name       string
+		token      string
+		path       string
+		wantStderr string
+	}{
+		{"permission denied", "s.wrong", "aws/dev", "permission denied"},
+		{"not found", "s.token", "aws/missing", ""},
+		{"missing keys", "s.token", "aws/dev", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := NewCredentialSource(server.URL, "secret", tt.path, func(s *CredentialSource) {
+				s.Token = tt.token
+				if tt.name == "missing keys" {
+					s.AccessKeyIDField = "nonexistent"
+				}
+			})
+
+			_, err := source.Retrieve(context.Background())
+			vaultErr, ok := errors.AsType[*backend.Error](err)
+			if !ok {
+				t.Fatalf("err = %v, want *backend.Error", err)
+			}
+			if vaultErr.Backend != "vault" || vaultErr.Stderr != tt.wantStderr {
+				t.Errorf("err = %+v, want vault error with stderr %q", vaultErr, tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestCredentialSource_NoToken(t *testing.T) {
+	t.Setenv("VAULT_TOKEN", "")
+	source := NewCredentialSource("http://127.0.0.1:0", "secret", "aws/dev", func(s *CredentialSource) {
+		s.TokenFile = filepath.Join(t.TempDir(), "missing")
+	})
+
+	if _, err := source.Retrieve(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
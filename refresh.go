@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+	"github.com/scizorman/op-aws-credential-process/pkg/sessioncache"
+)
+
+const refreshLockTimeout = 5 * time.Minute
+
+func refreshLockPath(c *sessioncache.Provider) string {
+	return c.CachePath() + ".refresh"
+}
+
+// spawnBackgroundRefresh re-executes the command line args detached from the
+// terminal so the caller can return the still-valid cached session. The
+// child is handed lockPath and removes it when it is done.
+func spawnBackgroundRefresh(lockPath string, args []string) error {
+	if err := acquireRefreshLock(lockPath); err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		_ = os.Remove(lockPath)
+		return err
+	}
+
+	cmd := exec.Command(exe, append(slices.Clone(args), "--background-refresh="+lockPath, "--background-refresh-caller="+strconv.Itoa(os.Getpid()))...)
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		_ = os.Remove(lockPath)
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+func acquireRefreshLock(path string) error {
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > refreshLockTimeout {
+		_ = os.Remove(path)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// refreshMfaSource returns the --mfa-source the background refresh reads the
+// MFA code from. Sources that need nobody at the terminal are kept; prompts
+// are replaced by the backend's one-time password.
+func (cli *CLI) refreshMfaSource() (string, error) {
+	switch cli.MfaSource {
+	case "backend", "op", "op-totp":
+		return cli.MfaSource, nil
+	case "ykman":
+		return "", errors.New("--refresh-window cannot be used with --mfa-source ykman, which may wait for a touch")
+	}
+	return "backend", nil
+}
+
+// validateRefresh rejects --refresh-window when the refresh could not get an
+// MFA code without a prompt, rather than letting it fail unseen.
+func (cli *CLI) validateRefresh(base backend.Backend) error {
+	if cli.RefreshWindow <= 0 {
+		return nil
+	}
+	source, err := cli.refreshMfaSource()
+	if err != nil {
+		return err
+	}
+	if _, ok := base.(backend.OTPBackend); source == "backend" && !ok {
+		return fmt.Errorf("--refresh-window needs MFA codes from the backend, which --backend %s cannot read; use --mfa-source op-totp or op", base.Name())
+	}
+	return nil
+}
+
+func (cli *CLI) backgroundRefresh() error {
+	defer func() {
+		_ = os.Remove(cli.BackgroundRefresh)
+	}()
+	ctx := context.Background()
+
+	cfg, err := cli.loadSharedConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The refresh runs without a terminal, so it cannot prompt for the MFA
+	// code.
+	cli.MfaSource, err = cli.refreshMfaSource()
+	if err != nil {
+		return err
+	}
+	source, err := cli.newCachedSessionProvider(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	_, err = source.Renew(ctx)
+	return err
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/audit"
+	"github.com/scizorman/op-aws-credential-process/pkg/opcreds"
+	"github.com/scizorman/op-aws-credential-process/pkg/vaultcreds"
+)
+
+func TestAcquireRefreshLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.json.refresh")
+
+	if err := acquireRefreshLock(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := acquireRefreshLock(path); !errors.Is(err, os.ErrExist) {
+		t.Errorf("second acquire error = %v, want os.ErrExist", err)
+	}
+
+	stale := time.Now().Add(-refreshLockTimeout - time.Minute)
+	if err := os.Chtimes(path, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	if err := acquireRefreshLock(path); err != nil {
+		t.Errorf("acquire over a stale lock error = %v, want nil", err)
+	}
+}
+
+func TestSpawnBackgroundRefresh_Contention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.json.refresh")
+	if err := acquireRefreshLock(path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := spawnBackgroundRefresh(path, []string{"-test.run=^$"}); !errors.Is(err, os.ErrExist) {
+		t.Errorf("error = %v, want os.ErrExist while another refresh holds the lock", err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("lock of the other refresh was removed: %v", err)
+	}
+	if !after.ModTime().Equal(info.ModTime()) {
+		t.Error("lock of the other refresh was replaced")
+	}
+}
+
+func TestSpawnBackgroundRefresh_StaleLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.json.refresh")
+	if err := acquireRefreshLock(path); err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-refreshLockTimeout - time.Minute)
+	if err := os.Chtimes(path, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	// The test binary stands in for the refresh and runs no tests.
+	if err := spawnBackgroundRefresh(path, []string{"-test.run=^$"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("expected a new lock: %v", err)
+	}
+	if !info.ModTime().After(stale) {
+		t.Error("stale lock was not replaced")
+	}
+}
+
+func TestCLI_BackgroundRefresh_RemovesLockOnError(t *testing.T) {
+	args := setupSTSTest(t, "[profile e2e]\nregion = us-east-1\nmfa_serial = arn:aws:iam::123456789012:mfa/user\n")
+	path := filepath.Join(t.TempDir(), "profile.json.refresh")
+	if err := acquireRefreshLock(path); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := parseCLI(t, append(args, "--expiry-window", "2h", "--duration", "1h", "--background-refresh="+path))
+	if err := cli.backgroundRefresh(); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("lock still exists after a failed refresh: %v", err)
+	}
+}
+
+func TestCLI_BackgroundRefresh_AuditsStarter(t *testing.T) {
+	server := newFakeSTSServer(t)
+	args := setupSTSTest(t, "[profile e2e]\nregion = us-east-1\nmfa_serial = arn:aws:iam::123456789012:mfa/user\n")
+	path := filepath.Join(t.TempDir(), "profile.json.refresh")
+	if err := acquireRefreshLock(path); err != nil {
+		t.Fatal(err)
+	}
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+
+	cli := parseCLI(t, append(args, "--sts-endpoint", server.URL, "--audit-log", auditPath, "--background-refresh="+path, "--background-refresh-caller="+strconv.Itoa(os.Getpid())))
+	if err := cli.backgroundRefresh(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	records, err := cli.auditLog().Records()
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	if len(records) != 1 || records[0].CallerKind != audit.CallerBackgroundRefresh || records[0].CallerPID != os.Getpid() {
+		t.Errorf("records = %+v, want a background refresh started by %d", records, os.Getpid())
+	}
+}
+
+func TestCLI_ValidateRefresh(t *testing.T) {
+	op := opcreds.NewCredentialSource("vault", "item")
+	vault := vaultcreds.NewCredentialSource("https://vault.example.com", "secret", "aws")
+
+	tests := []struct {
+		name      string
+		mfaSource string
+		window    time.Duration
+		vault     bool
+		wantErr   bool
+		want      string
+	}{
+		{name: "disabled", mfaSource: "ykman"},
+		{name: "auto", mfaSource: "auto", window: time.Hour, want: "backend"},
+		{name: "pinentry", mfaSource: "pinentry", window: time.Hour, want: "backend"},
+		{name: "op-totp", mfaSource: "op-totp", window: time.Hour, want: "op-totp"},
+		{name: "op", mfaSource: "op", window: time.Hour, want: "op"},
+		{name: "ykman", mfaSource: "ykman", window: time.Hour, wantErr: true},
+		{name: "vault without OTP", mfaSource: "auto", window: time.Hour, vault: true, wantErr: true},
+		{name: "vault with op-totp", mfaSource: "op-totp", window: time.Hour, vault: true, want: "op-totp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := &CLI{MfaSource: tt.mfaSource, RefreshWindow: tt.window}
+			var err error
+			if tt.vault {
+				err = cli.validateRefresh(vault)
+			} else {
+				err = cli.validateRefresh(op)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr || tt.window == 0 {
+				return
+			}
+			if got, _ := cli.refreshMfaSource(); got != tt.want {
+				t.Errorf("refreshMfaSource() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/backend"
+)
+
+// loadSharedConfig reads the profile section from the same files
+// loadAWSConfig does, including AWS_CONFIG_FILE and
+// AWS_SHARED_CREDENTIALS_FILE, which LoadSharedConfigProfile ignores.
+func (cli *CLI) loadSharedConfig(ctx context.Context) (config.SharedConfig, error) {
+	return config.LoadSharedConfigProfile(ctx, cli.Profile, func(o *config.LoadSharedConfigOptions) {
+		if f := os.Getenv("AWS_CONFIG_FILE"); f != "" {
+			o.ConfigFiles = []string{f}
+		}
+		if f := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); f != "" {
+			o.CredentialsFiles = []string{f}
+		}
+	})
+}
+
+// loadAWSConfig resolves the profile the way the SDK does, so endpoint_url,
+// the services section, use_fips_endpoint, AWS_ENDPOINT_URL_STS, ca_bundle,
+// max_attempts and retry_mode apply to the clients built from it, and
+// HTTPS_PROXY to their transport. creds replaces the profile's own
+// credentials, which would be this program.
+func (cli *CLI) loadAWSConfig(ctx context.Context, creds aws.CredentialsProvider) (aws.Config, error) {
+	optFns := []func(*config.LoadOptions) error{
+		config.WithSharedConfigProfile(cli.Profile),
+		config.WithCredentialsProvider(creds),
+	}
+	if cli.StsTimeout > 0 {
+		optFns = append(optFns, config.WithHTTPClient(awshttp.NewBuildableClient().WithTimeout(cli.StsTimeout)))
+	}
+	if cli.CaBundle != "" {
+		pem, err := os.ReadFile(cli.CaBundle)
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("--ca-bundle: %w", err)
+		}
+		optFns = append(optFns, config.WithCustomCABundle(bytes.NewReader(pem)))
+	}
+	return config.LoadDefaultConfig(ctx, optFns...)
+}
+
+// httpClient is the HTTP client of the AWS SDK, so that requests made
+// outside it, such as to the federation endpoint, honour --ca-bundle,
+// --sts-timeout and the proxy settings as STS calls do.
+func (cli *CLI) httpClient(ctx context.Context) (aws.HTTPClient, error) {
+	cfg, err := cli.loadAWSConfig(ctx, aws.AnonymousCredentials{})
+	if err != nil {
+		return nil, err
+	}
+	// The SDK leaves HTTPClient unset unless an option changes it, and its
+	// clients fall back to this default.
+	if cfg.HTTPClient == nil {
+		return awshttp.NewBuildableClient(), nil
+	}
+	return cfg.HTTPClient, nil
+}
+
+func (cli *CLI) newSTSClient(cfg aws.Config) *sts.Client {
+	return sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if cli.StsEndpoint != "" {
+			o.BaseEndpoint = aws.String(cli.StsEndpoint)
+		}
+	})
+}
+
+func newIAMClient(cfg aws.Config) *iam.Client {
+	return iam.NewFromConfig(cfg)
+}
+
+// sourceIdentityAuto is the --source-identity value asking for
+// defaultSourceIdentity.
+const sourceIdentityAuto = "auto"
+
+func (cli *CLI) validateSessionTags() error {
+	if cli.RoleArn == "" {
+		if len(cli.Tag) > 0 || len(cli.TransitiveTagKey) > 0 || cli.SourceIdentity != "" {
+			return errors.New("--tag, --transitive-tag-key and --source-identity require --role-arn")
+		}
+		return nil
+	}
+	for _, key := range cli.TransitiveTagKey {
+		if _, ok := cli.Tag[key]; !ok {
+			return fmt.Errorf("--transitive-tag-key %s is not the key of a --tag", key)
+		}
+	}
+	return nil
+}
+
+func (cli *CLI) validateSessionPolicies() error {
+	if cli.RoleArn == "" && (cli.SessionPolicyFile != "" || len(cli.SessionPolicyArn) > 0) {
+		return errors.New("--session-policy-file and --session-policy-arn require --role-arn")
+	}
+	return nil
+}
+
+// readPolicyFile returns the policy document in path, or "" when path is
+// empty. The document is checked by the provider sending it.
+func readPolicyFile(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// sourceIdentity returns --source-identity and, for "auto", the function
+// resolving it. The cache is keyed by "auto", so that the backend is only
+// asked who is signed in when a new session is needed.
+func (cli *CLI) sourceIdentity(base backend.Backend) (string, func(context.Context) (string, error)) {
+	if cli.SourceIdentity != sourceIdentityAuto {
+		return cli.SourceIdentity, nil
+	}
+	return "", func(ctx context.Context) (string, error) {
+		return defaultSourceIdentity(ctx, base)
+	}
+}
+
+// defaultSourceIdentity names the person behind a session: the account
+// signed in to the backend when it has accounts, or else the OS user.
+func defaultSourceIdentity(ctx context.Context, base backend.Backend) (string, error) {
+	if userBackend, ok := base.(backend.UserBackend); ok {
+		name, err := userBackend.User(ctx)
+		if err != nil {
+			// The OS user would name someone else than the account.
+			return "", fmt.Errorf("failed to resolve --source-identity auto from the %s account: %w", base.Name(), err)
+		}
+		return sanitizeSTSName(name, maxSourceIdentityLen), nil
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return sanitizeSTSName(u.Username, maxSourceIdentityLen), nil
+}
+
+const (
+	maxSourceIdentityLen = 64
+	maxFederationNameLen = 32
+)
+
+// sanitizeSTSName fits name into what STS accepts as a source identity or
+// federated user name: at most maxLen characters out of letters, digits and
+// _+=,.@-.
+func sanitizeSTSName(name string, maxLen int) string {
+	name = strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_+=,.@-", r)) {
+			return r
+		}
+		return '-'
+	}, name)
+	if len(name) > maxLen {
+		name = name[:maxLen]
+	}
+	return name
+}
@@ -0,0 +1,631 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/scizorman/op-aws-credential-process/internal/testutil"
+	"github.com/scizorman/op-aws-credential-process/pkg/stssession"
+)
+
+// fakeSTSServer stands in for STS, answering any action with canned XML
+// credentials and recording the form of the last request. With status set it
+// fails with that status instead, and with hang it never answers.
+type fakeSTSServer struct {
+	*httptest.Server
+	status   int
+	hang     bool
+	requests int
+	form     map[string]string
+	auth     string
+}
+
+func newFakeSTSServer(t *testing.T) *fakeSTSServer {
+	t.Helper()
+	f := &fakeSTSServer{}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// newFakeSTSTLSServer is newFakeSTSServer over HTTPS with a certificate
+// from a CA no system trusts.
+func newFakeSTSTLSServer(t *testing.T) *fakeSTSServer {
+	t.Helper()
+	f := &fakeSTSServer{}
+	f.Server = httptest.NewTLSServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// writeCABundle writes the CA certificate of a TLS server as PEM.
+func (f *fakeSTSServer) writeCABundle(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func (f *fakeSTSServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests++
+	f.auth = r.Header.Get("Authorization")
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.form = make(map[string]string)
+	for k := range r.PostForm {
+		f.form[k] = r.PostForm.Get(k)
+	}
+
+	if f.hang {
+		<-r.Context().Done()
+		return
+	}
+	if f.status != 0 {
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(f.status)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Receiver</Type><Code>InternalFailure</Code><Message>try again</Message></Error></ErrorResponse>`)
+		return
+	}
+
+	action := f.form["Action"]
+	w.Header().Set("Content-Type", "text/xml")
+	w.Header().Set("X-Amzn-Requestid", "00000000-0000-0000-0000-000000000000")
+	fmt.Fprintf(w, `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%[1]sResult>
+    <Credentials>
+      <AccessKeyId>ASIAFAKESTS</AccessKeyId>
+      <SecretAccessKey>session-secret</SecretAccessKey>
+      <SessionToken>session-token</SessionToken>
+      <Expiration>%[2]s</Expiration>
+    </Credentials>
+  </%[1]sResult>
+  <ResponseMetadata>
+    <RequestId>00000000-0000-0000-0000-000000000000</RequestId>
+  </ResponseMetadata>
+</%[1]sResponse>`, action, time.Now().Add(12*time.Hour).UTC().Format(time.RFC3339))
+}
+
+// setupSTSTest isolates the shared config, cache, IAM and 1Password from the
+// user's and returns the arguments selecting a fake op for the access key
+// and the MFA code.
+func setupSTSTest(t *testing.T, awsConfig string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	if err := os.WriteFile(configFile, []byte(awsConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	for _, name := range []string{"AWS_CA_BUNDLE", "AWS_MAX_ATTEMPTS", "AWS_RETRY_MODE", "AWS_PROFILE", "AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ENDPOINT_URL", "AWS_ENDPOINT_URL_STS", "AWS_USE_FIPS_ENDPOINT"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+
+	// IAM denies everything, as it does for roles in other accounts, so that
+	// the role's maximum session duration is never looked up on AWS.
+	iamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AccessDenied</Code><Message>denied</Message></Error></ErrorResponse>`)
+	}))
+	t.Cleanup(iamServer.Close)
+	t.Setenv("AWS_ENDPOINT_URL_IAM", iamServer.URL)
+
+	op := testutil.WriteCommand(t, "op", `case "$*" in
+*--otp*) echo 123456 ;;
+*) echo '[{"label":"Access key ID","value":"AKIAEXAMPLE"},{"label":"Secret access key","value":"secret"}]' ;;
+esac
+`)
+	return []string{"--profile", "e2e", "--op-vault", "Private", "--op-item", "aws", "--op-cli-path", op, "--mfa-source", "op"}
+}
+
+func parseCLI(t *testing.T, args []string) *CLI {
+	t.Helper()
+	var cli CLI
+	parser, err := kong.New(&cli, kongOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return &cli
+}
+
+func TestNewCachedSessionProvider_STSEndpoint(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(url string) string
+		env    func(url string) map[string]string
+		flag   bool
+	}{
+		{
+			name: "flag",
+			config: func(string) string {
+				return "[profile e2e]\nregion = us-east-1\nmfa_serial = arn:aws:iam::123456789012:mfa/user\n"
+			},
+			flag: true,
+		},
+		{
+			name: "AWS_ENDPOINT_URL_STS",
+			config: func(string) string {
+				return "[profile e2e]\nregion = us-east-1\nmfa_serial = arn:aws:iam::123456789012:mfa/user\n"
+			},
+			env: func(url string) map[string]string {
+				return map[string]string{"AWS_ENDPOINT_URL_STS": url}
+			},
+		},
+		{
+			name: "services section",
+			config: func(url string) string {
+				return "[profile e2e]\nregion = us-east-1\nmfa_serial = arn:aws:iam::123456789012:mfa/user\nservices = local\n\n[services local]\nsts =\n  endpoint_url = " + url + "\n"
+			},
+		},
+		{
+			name: "flag overrides endpoint_url",
+			config: func(string) string {
+				return "[profile e2e]\nregion = us-east-1\nmfa_serial = arn:aws:iam::123456789012:mfa/user\nendpoint_url = http://127.0.0.1:1\n"
+			},
+			flag: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeSTSServer(t)
+			args := setupSTSTest(t, tt.config(server.URL))
+			if tt.env != nil {
+				for k, v := range tt.env(server.URL) {
+					t.Setenv(k, v)
+				}
+			}
+			if tt.flag {
+				args = append(args, "--sts-endpoint", server.URL)
+			}
+			cli := parseCLI(t, args)
+
+			ctx := context.Background()
+			cfg, err := cli.loadSharedConfig(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			provider, err := cli.newCachedSessionProvider(ctx, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			creds, cached, err := provider.Fetch(ctx)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if cached || aws.ToString(creds.AccessKeyId) != "ASIAFAKESTS" {
+				t.Errorf("creds = %s (cached %t), want ASIAFAKESTS from STS", aws.ToString(creds.AccessKeyId), cached)
+			}
+			if server.requests != 1 {
+				t.Fatalf("requests = %d, want 1", server.requests)
+			}
+			want := map[string]string{
+				"Action":       "GetSessionToken",
+				"SerialNumber": "arn:aws:iam::123456789012:mfa/user",
+				"TokenCode":    "123456",
+			}
+			for k, v := range want {
+				if server.form[k] != v {
+					t.Errorf("%s = %q, want %q", k, server.form[k], v)
+				}
+			}
+			if !strings.Contains(server.auth, "Credential=AKIAEXAMPLE/") || !strings.Contains(server.auth, "/us-east-1/sts/") {
+				t.Errorf("Authorization = %q, want it signed by AKIAEXAMPLE for us-east-1 sts", server.auth)
+			}
+		})
+	}
+}
+
+func TestNewSTSClient_FIPS(t *testing.T) {
+	args := setupSTSTest(t, "[profile e2e]\nregion = us-gov-west-1\nuse_fips_endpoint = true\n")
+	cli := parseCLI(t, args)
+
+	cfg, err := cli.loadAWSConfig(context.Background(), aws.AnonymousCredentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := cli.newSTSClient(cfg).Options()
+	if opts.Region != "us-gov-west-1" || opts.EndpointOptions.UseFIPSEndpoint != aws.FIPSEndpointStateEnabled {
+		t.Errorf("region = %q, FIPS = %v, want us-gov-west-1 with FIPS enabled", opts.Region, opts.EndpointOptions.UseFIPSEndpoint)
+	}
+}
+
+// fetchSession builds the provider for args and asks it for a session.
+func fetchSession(t *testing.T, args []string) error {
+	t.Helper()
+	cli := parseCLI(t, args)
+	ctx := context.Background()
+	cfg, err := cli.loadSharedConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := cli.newCachedSessionProvider(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	_, _, err = provider.Fetch(ctx)
+	return err
+}
+
+func TestNewCachedSessionProvider_CABundle(t *testing.T) {
+	const profile = "[profile e2e]\nregion = us-east-1\nmfa_serial = arn:aws:iam::123456789012:mfa/user\n"
+
+	t.Run("untrusted", func(t *testing.T) {
+		server := newFakeSTSTLSServer(t)
+		args := setupSTSTest(t, profile+"max_attempts = 1\n")
+		err := fetchSession(t, append(args, "--sts-endpoint", server.URL))
+		if err == nil || !strings.Contains(err.Error(), "certificate") {
+			t.Errorf("err = %v, want a certificate error", err)
+		}
+	})
+
+	t.Run("flag", func(t *testing.T) {
+		server := newFakeSTSTLSServer(t)
+		args := setupSTSTest(t, profile)
+		if err := fetchSession(t, append(args, "--sts-endpoint", server.URL, "--ca-bundle", server.writeCABundle(t))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if server.requests != 1 {
+			t.Errorf("requests = %d, want 1", server.requests)
+		}
+	})
+
+	t.Run("profile", func(t *testing.T) {
+		server := newFakeSTSTLSServer(t)
+		args := setupSTSTest(t, profile+"ca_bundle = "+server.writeCABundle(t)+"\n")
+		if err := fetchSession(t, append(args, "--sts-endpoint", server.URL)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestNewCachedSessionProvider_MaxAttempts(t *testing.T) {
+	server := newFakeSTSServer(t)
+	server.status = http.StatusInternalServerError
+	args := setupSTSTest(t, "[profile e2e]\nregion = us-east-1\nmfa_serial = arn:aws:iam::123456789012:mfa/user\nmax_attempts = 2\n")
+
+	if err := fetchSession(t, append(args, "--sts-endpoint", server.URL)); err == nil {
+		t.Fatal("expected error")
+	}
+	if server.requests != 2 {
+		t.Errorf("requests = %d, want 2", server.requests)
+	}
+}
+
+func TestNewCachedSessionProvider_STSTimeout(t *testing.T) {
+	server := newFakeSTSServer(t)
+	server.hang = true
+	args := setupSTSTest(t, "[profile e2e]\nregion = us-east-1\nmfa_serial = arn:aws:iam::123456789012:mfa/user\nmax_attempts = 1\n")
+
+	start := time.Now()
+	if err := fetchSession(t, append(args, "--sts-endpoint", server.URL, "--sts-timeout", "100ms")); err == nil {
+		t.Fatal("expected error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("elapsed = %v, want the request to time out", elapsed)
+	}
+}
+
+func TestCLI_ValidateSessionTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "no tags", args: nil},
+		{name: "tags with role", args: []string{"--role-arn", "arn:aws:iam::222222222222:role/Admin", "--tag", "team=platform", "--tag", "project=billing", "--transitive-tag-key", "team"}},
+		{name: "tags without role", args: []string{"--tag", "team=platform"}, wantErr: true},
+		{name: "source identity without role", args: []string{"--source-identity", "auto"}, wantErr: true},
+		{name: "transitive key not tagged", args: []string{"--role-arn", "arn:aws:iam::222222222222:role/Admin", "--tag", "team=platform", "--transitive-tag-key", "project"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := parseCLI(t, append([]string{"--op-vault", "v", "--op-item", "i"}, tt.args...))
+			if err := cli.validateSessionTags(); (err != nil) != tt.wantErr {
+				t.Errorf("validateSessionTags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewCachedSessionProvider_SourceIdentityAuto(t *testing.T) {
+	server := newFakeSTSServer(t)
+	args := setupSTSTest(t, "[profile e2e]\nregion = us-east-1\nmfa_serial = arn:aws:iam::123456789012:mfa/user\n")
+	whoamiLog := filepath.Join(t.TempDir(), "whoami")
+	op := testutil.WriteCommand(t, "op", fmt.Sprintf(`case "$*" in
+whoami*) echo >> %q; echo '{"email":"alice@example.com"}' ;;
+*--otp*) echo 123456 ;;
+*) echo '[{"label":"Access key ID","value":"AKIAEXAMPLE"},{"label":"Secret access key","value":"secret"}]' ;;
+esac
+`, whoamiLog))
+	args = append(args, "--op-cli-path", op, "--sts-endpoint", server.URL, "--role-arn", "arn:aws:iam::222222222222:role/Admin", "--duration", "1h")
+	auto := append(slices.Clone(args), "--source-identity", "auto")
+
+	for range 2 {
+		if err := fetchSession(t, auto); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got := server.form["SourceIdentity"]; got != "alice@example.com" {
+		t.Errorf("SourceIdentity = %q, want %q", got, "alice@example.com")
+	}
+	if data, _ := os.ReadFile(whoamiLog); len(data) != 1 {
+		t.Errorf("op whoami called %d times, want once on the cache miss", len(data))
+	}
+
+	data, err := os.ReadFile(cachePath(t, auto))
+	if err != nil {
+		t.Fatalf("failed to read cache: %v", err)
+	}
+	var entry struct {
+		SourceIdentity         string `json:"source_identity"`
+		ResolvedSourceIdentity string `json:"resolved_source_identity"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("failed to parse cache: %v", err)
+	}
+	if entry.SourceIdentity != "auto" || entry.ResolvedSourceIdentity != "alice@example.com" {
+		t.Errorf("cache entry = %+v, want auto resolved to alice@example.com", entry)
+	}
+}
+
+func TestNewCachedSessionProvider_SourceIdentityAutoError(t *testing.T) {
+	server := newFakeSTSServer(t)
+	args := setupSTSTest(t, "[profile e2e]\nregion = us-east-1\nmfa_serial = arn:aws:iam::123456789012:mfa/user\n")
+	op := testutil.WriteCommand(t, "op", `case "$*" in
+whoami*) echo "not signed in" >&2; exit 1 ;;
+*--otp*) echo 123456 ;;
+*) echo '[{"label":"Access key ID","value":"AKIAEXAMPLE"},{"label":"Secret access key","value":"secret"}]' ;;
+esac
+`)
+	args = append(args, "--op-cli-path", op, "--sts-endpoint", server.URL, "--role-arn", "arn:aws:iam::222222222222:role/Admin", "--duration", "1h", "--source-identity", "auto")
+
+	if err := fetchSession(t, args); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if server.requests != 0 {
+		t.Errorf("STS called %d times, want none without a source identity", server.requests)
+	}
+}
+
+func TestSanitizeSTSName(t *testing.T) {
+	tests := map[string]string{
+		"alice@example.com":     "alice@example.com",
+		`CORP\alice`:            "CORP-alice",
+		"ålice smith":           "-lice-smith",
+		strings.Repeat("a", 70): strings.Repeat("a", 64),
+	}
+	for name, want := range tests {
+		if got := sanitizeSTSName(name, maxSourceIdentityLen); got != want {
+			t.Errorf("sanitizeSTSName(%q, %d) = %q, want %q", name, maxSourceIdentityLen, got, want)
+		}
+	}
+}
+
+func TestNewCachedSessionProvider_WebIdentity(t *testing.T) {
+	const roleArn = "arn:aws:iam::123456789012:role/ci"
+	tests := []struct {
+		name    string
+		profile func(tokenFile string) string
+		args    func(tokenFile string) []string
+	}{
+		{
+			name: "profile",
+			profile: func(tokenFile string) string {
+				return "role_arn = " + roleArn + "\nweb_identity_token_file = " + tokenFile + "\n"
+			},
+		},
+		{
+			name: "flag",
+			args: func(tokenFile string) []string {
+				return []string{"--role-arn", roleArn, "--web-identity-token-file", tokenFile}
+			},
+		},
+		{
+			name: "command",
+			args: func(tokenFile string) []string {
+				return []string{"--role-arn", roleArn, "--web-identity-token-command", "cat " + tokenFile}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeSTSServer(t)
+			tokenFile := filepath.Join(t.TempDir(), "token")
+			if err := os.WriteFile(tokenFile, []byte("eyJ.token.sig\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			profile := "[profile e2e]\nregion = us-east-1\n"
+			if tt.profile != nil {
+				profile += tt.profile(tokenFile)
+			}
+			setupSTSTest(t, profile)
+			args := []string{"--profile", "e2e", "--sts-endpoint", server.URL, "--duration", "1h"}
+			if tt.args != nil {
+				args = append(args, tt.args(tokenFile)...)
+			}
+
+			for range 2 {
+				if err := fetchSession(t, args); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if server.requests != 1 {
+				t.Errorf("requests = %d, want 1 with the second session from the cache", server.requests)
+			}
+			if got := server.form["Action"]; got != "AssumeRoleWithWebIdentity" {
+				t.Errorf("Action = %q, want AssumeRoleWithWebIdentity", got)
+			}
+			if got := server.form["WebIdentityToken"]; got != "eyJ.token.sig" {
+				t.Errorf("WebIdentityToken = %q, want %q", got, "eyJ.token.sig")
+			}
+			if got := server.form["RoleArn"]; got != roleArn {
+				t.Errorf("RoleArn = %q, want %q", got, roleArn)
+			}
+			if server.auth != "" {
+				t.Errorf("Authorization = %q, want an unsigned request", server.auth)
+			}
+		})
+	}
+}
+
+func TestNewCachedSessionProvider_WebIdentityWithoutRole(t *testing.T) {
+	setupSTSTest(t, "[profile e2e]\nregion = us-east-1\n")
+	err := fetchSession(t, []string{"--profile", "e2e", "--web-identity-token-file", "/dev/null"})
+	if err == nil || !strings.Contains(err.Error(), "role_arn") {
+		t.Errorf("err = %v, want an error about the missing role", err)
+	}
+}
+
+func TestNewCachedSessionProvider_Federation(t *testing.T) {
+	server := newFakeSTSServer(t)
+	args := setupSTSTest(t, "[profile e2e]\nregion = us-east-1\nmfa_serial = arn:aws:iam::123456789012:mfa/user\n")
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	const policy = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}]}`
+	if err := os.WriteFile(policyFile, []byte(policy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	args = append(args, "--sts-endpoint", server.URL, "--federation", "--federation-name", "alice", "--policy-file", policyFile)
+
+	for range 2 {
+		if err := fetchSession(t, append(args, "--policy-arn", "arn:aws:iam::aws:policy/ReadOnlyAccess")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if server.requests != 1 {
+		t.Errorf("requests = %d, want 1 with the second session from the cache", server.requests)
+	}
+	want := map[string]string{
+		"Action":                  "GetFederationToken",
+		"Name":                    "alice",
+		"Policy":                  policy,
+		"PolicyArns.member.1.arn": "arn:aws:iam::aws:policy/ReadOnlyAccess",
+	}
+	for key, value := range want {
+		if got := server.form[key]; got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	if _, ok := server.form["TokenCode"]; ok {
+		t.Error("TokenCode was sent, want no MFA for GetFederationToken")
+	}
+
+	if err := fetchSession(t, args); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if server.requests != 2 {
+		t.Errorf("requests = %d, want 2 with a session for other policies not served from the cache", server.requests)
+	}
+}
+
+func TestCLI_ValidateFederation(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "no federation", args: nil},
+		{name: "policy arn", args: []string{"--federation", "--policy-arn", "arn:aws:iam::aws:policy/ReadOnlyAccess"}},
+		{name: "policy file", args: []string{"--federation", "--policy-file", "policy.json"}},
+		{name: "no policies", args: []string{"--federation"}, wantErr: true},
+		{name: "with role", args: []string{"--federation", "--policy-arn", "arn:aws:iam::aws:policy/ReadOnlyAccess", "--role-arn", "arn:aws:iam::222222222222:role/Admin"}, wantErr: true},
+		{name: "policies without federation", args: []string{"--policy-arn", "arn:aws:iam::aws:policy/ReadOnlyAccess"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := parseCLI(t, append([]string{"--op-vault", "v", "--op-item", "i"}, tt.args...))
+			if err := cli.validateFederation(); (err != nil) != tt.wantErr {
+				t.Errorf("validateFederation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewCachedSessionProvider_SessionPolicy(t *testing.T) {
+	server := newFakeSTSServer(t)
+	args := setupSTSTest(t, "[profile e2e]\nregion = us-east-1\nmfa_serial = arn:aws:iam::123456789012:mfa/user\n")
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	policy := "{\n  \"Version\": \"2012-10-17\",\n  \"Statement\": [{\"Effect\": \"Allow\", \"Action\": \"s3:Get*\", \"Resource\": \"*\"}]\n}\n"
+	if err := os.WriteFile(policyFile, []byte(policy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	args = append(args, "--sts-endpoint", server.URL, "--role-arn", "arn:aws:iam::222222222222:role/Admin", "--duration", "1h")
+	scoped := append(slices.Clone(args), "--session-policy-file", policyFile, "--session-policy-arn", "arn:aws:iam::aws:policy/ReadOnlyAccess")
+
+	for range 2 {
+		if err := fetchSession(t, scoped); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if server.requests != 1 {
+		t.Errorf("requests = %d, want 1 with the second session from the cache", server.requests)
+	}
+	if got, want := server.form["Policy"], `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:Get*","Resource":"*"}]}`; got != want {
+		t.Errorf("Policy = %q, want %q", got, want)
+	}
+	if got := server.form["PolicyArns.member.1.arn"]; got != "arn:aws:iam::aws:policy/ReadOnlyAccess" {
+		t.Errorf("PolicyArns.member.1.arn = %q, want arn:aws:iam::aws:policy/ReadOnlyAccess", got)
+	}
+
+	if cachePath(t, args) == cachePath(t, scoped) {
+		t.Error("unscoped request shares the cache of the scoped session")
+	}
+}
+
+// cachePath returns where the session for args is cached.
+func cachePath(t *testing.T, args []string) string {
+	t.Helper()
+	cli := parseCLI(t, args)
+	ctx := context.Background()
+	cfg, err := cli.loadSharedConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := cli.newCachedSessionProvider(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider.CachePath()
+}
+
+func TestNewCachedSessionProvider_InvalidSessionPolicy(t *testing.T) {
+	server := newFakeSTSServer(t)
+	args := setupSTSTest(t, "[profile e2e]\nregion = us-east-1\nmfa_serial = arn:aws:iam::123456789012:mfa/user\n")
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(policyFile, []byte(`{"Statement":[{"Effect":"Allow","Action":"s3:Get*","Resource":"*"}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := fetchSession(t, append(args, "--sts-endpoint", server.URL, "--role-arn", "arn:aws:iam::222222222222:role/Admin", "--session-policy-file", policyFile))
+	if _, ok := errors.AsType[*stssession.PolicyError](err); !ok {
+		t.Errorf("err = %v, want *stssession.PolicyError", err)
+	}
+	if server.requests != 0 {
+		t.Errorf("requests = %d, want 0", server.requests)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/sessioncache"
+	"github.com/scizorman/op-aws-credential-process/pkg/stssession"
+)
+
+// webIdentityBackend stands in for the backend name in cache entries and
+// audit records of sessions assumed with an OIDC token.
+const webIdentityBackend = "web_identity"
+
+// webIdentityToken returns where to read the OIDC token from, if the flags
+// or the profile name one.
+func (cli *CLI) webIdentityToken(cfg config.SharedConfig) (stssession.TokenSource, bool) {
+	switch {
+	case cli.WebIdentityTokenCommand != "":
+		return stssession.TokenCommand(cli.WebIdentityTokenCommand), true
+	case cli.WebIdentityTokenFile != "":
+		return stssession.TokenFile(cli.WebIdentityTokenFile), true
+	case cfg.WebIdentityTokenFile != "":
+		return stssession.TokenFile(cfg.WebIdentityTokenFile), true
+	}
+	return nil, false
+}
+
+// newWebIdentitySessionProvider assumes the role with an OIDC token, which
+// needs neither the backend nor MFA.
+func (cli *CLI) newWebIdentitySessionProvider(ctx context.Context, cfg config.SharedConfig, token stssession.TokenSource) (*sessioncache.Provider, error) {
+	if len(cli.Tag) > 0 || len(cli.TransitiveTagKey) > 0 || cli.SourceIdentity != "" {
+		return nil, errors.New("--tag, --transitive-tag-key and --source-identity cannot be used with a web identity token")
+	}
+	if cli.Federation {
+		return nil, errors.New("--federation cannot be used with a web identity token")
+	}
+	roleArn := cli.RoleArn
+	if roleArn == "" {
+		roleArn = cfg.RoleARN
+	}
+	if roleArn == "" {
+		return nil, errors.New("a web identity token needs --role-arn or role_arn in the profile")
+	}
+
+	policy, err := readPolicyFile(cli.SessionPolicyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	awsCfg, err := cli.loadAWSConfig(ctx, aws.AnonymousCredentials{})
+	if err != nil {
+		return nil, err
+	}
+	sessionProvider := stssession.NewWebIdentityProvider(cli.newSTSClient(awsCfg), token, roleArn, func(p *stssession.WebIdentityProvider) {
+		p.RoleSessionName = cli.RoleSessionName
+		p.Duration = cli.Duration
+		p.Policy = policy
+		p.PolicyArns = cli.SessionPolicyArn
+	})
+
+	return cli.cacheSession(sessionProvider, func(c *sessioncache.Provider) {
+		c.Backend = webIdentityBackend
+		c.Item.Item = webIdentityTokenName(token)
+		c.RoleArn = roleArn
+		c.Policy = policy
+		c.PolicyArns = cli.SessionPolicyArn
+	})
+}
+
+// webIdentityTokenName is the token file or command, so that the cached
+// session is not served for another token source.
+func webIdentityTokenName(token stssession.TokenSource) string {
+	switch t := token.(type) {
+	case stssession.TokenFile:
+		return string(t)
+	case stssession.TokenCommand:
+		return string(t)
+	}
+	return ""
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+
+	"github.com/scizorman/op-aws-credential-process/pkg/stssession"
+)
+
+type GetCallerIdentityAPIClient interface {
+	GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+}
+
+type whoamiCmd struct {
+	Format string `default:"table" enum:"table,json" help:"Output format (table, json)."`
+}
+
+func (c *whoamiCmd) Run(cli *CLI) error {
+	ctx := context.Background()
+
+	cfg, err := cli.loadSharedConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	source, err := cli.newCachedSessionProvider(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	creds, cached, err := source.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	awsCfg, err := cli.loadAWSConfig(ctx, credentials.NewStaticCredentialsProvider(
+		aws.ToString(creds.AccessKeyId),
+		aws.ToString(creds.SecretAccessKey),
+		aws.ToString(creds.SessionToken),
+	))
+	if err != nil {
+		return err
+	}
+	client := cli.newSTSClient(awsCfg)
+
+	identity, err := whoami(ctx, client, creds, cached)
+	if err != nil {
+		return err
+	}
+
+	return identity.write(os.Stdout, c.Format)
+}
+
+type callerIdentity struct {
+	Account    string    `json:"account"`
+	Arn        string    `json:"arn"`
+	UserID     string    `json:"user_id"`
+	Expiration time.Time `json:"expiration"`
+	Cached     bool      `json:"cached"`
+}
+
+func whoami(ctx context.Context, client GetCallerIdentityAPIClient, creds *ststypes.Credentials, cached bool) (*callerIdentity, error) {
+	out, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, &stssession.Error{Operation: "GetCallerIdentity", Err: err}
+	}
+
+	return &callerIdentity{
+		Account:    aws.ToString(out.Account),
+		Arn:        aws.ToString(out.Arn),
+		UserID:     aws.ToString(out.UserId),
+		Expiration: aws.ToTime(creds.Expiration),
+		Cached:     cached,
+	}, nil
+}
+
+func (i *callerIdentity) write(w io.Writer, format string) error {
+	if format == "json" {
+		return json.NewEncoder(w).Encode(i)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Account\t%s\n", i.Account)
+	fmt.Fprintf(tw, "ARN\t%s\n", i.Arn)
+	fmt.Fprintf(tw, "UserID\t%s\n", i.UserID)
+	fmt.Fprintf(tw, "Expiration\t%s\n", i.Expiration.Local().Format(time.RFC3339))
+	fmt.Fprintf(tw, "Cached\t%t\n", i.Cached)
+	return tw.Flush()
+}
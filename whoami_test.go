@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+type fakeCallerIdentityClient struct {
+	output *sts.GetCallerIdentityOutput
+	err    error
+}
+
+func (f *fakeCallerIdentityClient) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	return f.output, f.err
+}
+
+func TestWhoami(t *testing.T) {
+	exp := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	client := &fakeCallerIdentityClient{output: &sts.GetCallerIdentityOutput{
+		Account: aws.String("123456789012"),
+		Arn:     aws.String("arn:aws:iam::123456789012:user/alice"),
+		UserId:  aws.String("AIDAEXAMPLE"),
+	}}
+
+	got, err := whoami(context.Background(), client, newStsCreds("AKIA", "SECRET", "TOKEN", exp), true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := callerIdentity{
+		Account:    "123456789012",
+		Arn:        "arn:aws:iam::123456789012:user/alice",
+		UserID:     "AIDAEXAMPLE",
+		Expiration: exp,
+		Cached:     true,
+	}
+	if *got != want {
+		t.Errorf("whoami = %+v, want %+v", *got, want)
+	}
+}
+
+func TestWhoami_Error(t *testing.T) {
+	client := &fakeCallerIdentityClient{err: errors.New("access denied")}
+
+	_, err := whoami(context.Background(), client, newStsCreds("AKIA", "SECRET", "TOKEN", time.Now()), false)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestCallerIdentity_Write(t *testing.T) {
+	identity := &callerIdentity{
+		Account:    "123456789012",
+		Arn:        "arn:aws:iam::123456789012:user/alice",
+		UserID:     "AIDAEXAMPLE",
+		Expiration: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Cached:     false,
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := identity.write(&buf, "json"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got callerIdentity
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("failed to unmarshal output: %v", err)
+		}
+		if got != *identity {
+			t.Errorf("output = %+v, want %+v", got, *identity)
+		}
+	})
+
+	t.Run("table", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := identity.write(&buf, "table"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, want := range []string{"123456789012", "arn:aws:iam::123456789012:user/alice", "AIDAEXAMPLE", "false"} {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("output %q does not contain %q", buf.String(), want)
+			}
+		}
+	})
+}